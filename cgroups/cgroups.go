@@ -0,0 +1,192 @@
+// Package cgroups 为容器配置资源限制
+// 每个容器在 duoker 的 cgroup 下有一个以容器名命名的子 cgroup
+// v2 使用 unified 层级 /sys/fs/cgroup/duoker/<name>
+// v1 在每个控制器各自的挂载点下创建 例如 /sys/fs/cgroup/memory/duoker/<name>
+// 启动时自动检测宿主机使用的是哪一种.
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// cgroupRoot cgroup 文件系统的挂载点
+	cgroupRoot = "/sys/fs/cgroup"
+	// cgroupParent 所有容器 cgroup 的父 cgroup
+	cgroupParent = "duoker"
+	// cgroup2SuperMagic cgroup2 文件系统的 magic number
+	cgroup2SuperMagic = 0x63677270
+	// cpuPeriod --cpus 换算 cpu.max 时使用的周期 单位微秒
+	cpuPeriod = 100000
+)
+
+// Manager 管理一个容器的 cgroup v1 和 v2 分别实现.
+type Manager interface {
+	// Set 创建 cgroup 并写入资源限制
+	Set(r *Resources) error
+	// Apply 把进程加入 cgroup
+	// 在容器 init 进程 exec 用户命令之前调用 之后 fork 出的进程都会继承这个 cgroup
+	Apply(pid int) error
+	// Stats 读取 cgroup 的资源使用情况
+	Stats() (*Stats, error)
+	// Destroy 删除 cgroup
+	Destroy() error
+}
+
+// New 根据宿主机的 cgroup 版本返回容器的 cgroup 管理器 此时还没有创建 cgroup.
+func New(containerName string) (Manager, error) {
+	if IsUnified() {
+		return newV2Manager(cgroupRoot, containerName), nil
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("read mountinfo fail err=%s", err)
+	}
+	defer f.Close()
+	mounts, err := parseV1Mounts(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no cgroup v1 or v2 hierarchy found")
+	}
+	return newV1Manager(mounts, containerName), nil
+}
+
+// Stats cgroup 的资源使用情况 限制为 0 表示不限制.
+type Stats struct {
+	CPUUsage    uint64 `json:"cpuUsage"`    // 累计使用的 CPU 时间 纳秒
+	MemoryUsage uint64 `json:"memoryUsage"` // 当前使用的内存 字节
+	MemoryLimit uint64 `json:"memoryLimit"` // 内存上限 字节
+	Pids        uint64 `json:"pids"`        // 当前进程数
+	PidsLimit   uint64 `json:"pidsLimit"`   // 进程数上限
+	BlkioRead   uint64 `json:"blkioRead"`   // 累计读取的字节数
+	BlkioWrite  uint64 `json:"blkioWrite"`  // 累计写入的字节数
+}
+
+// Resources 容器的资源限制 为 0 的字段表示不限制.
+type Resources struct {
+	Memory     int64   `json:"memory,omitempty"`     // 内存上限 字节
+	MemorySwap int64   `json:"memorySwap,omitempty"` // 内存加 swap 的上限 -1 表示 swap 不限制
+	CPUs       float64 `json:"cpus,omitempty"`       // 可以使用的 CPU 核数 例如 1.5
+	CPUShares  int64   `json:"cpuShares,omitempty"`  // CPU 相对权重 和 docker 一样默认 1024 v2 上换算为 cpu.weight
+	CPUWeight  int64   `json:"cpuWeight,omitempty"`  // v2 的 cpu.weight [1, 10000] 默认 100 v1 上换算为 cpu.shares
+	CPUSetCPUs string  `json:"cpusetCpus,omitempty"` // 允许运行的 CPU 例如 0-2,4
+	PidsLimit  int64   `json:"pidsLimit,omitempty"`  // 进程数上限
+}
+
+// Empty 是否没有设置任何限制.
+func (r *Resources) Empty() bool {
+	return r == nil || *r == Resources{}
+}
+
+// Validate 检查参数是否合法.
+func (r *Resources) Validate() error {
+	if r.Memory < 0 || r.CPUs < 0 || r.CPUShares < 0 || r.CPUWeight < 0 || r.PidsLimit < 0 {
+		return fmt.Errorf("resource limits must not be negative")
+	}
+	if r.MemorySwap > 0 {
+		if r.Memory == 0 {
+			return fmt.Errorf("--memory-swap requires --memory")
+		}
+		if r.MemorySwap < r.Memory {
+			return fmt.Errorf("--memory-swap must be larger than or equal to --memory")
+		}
+	}
+	if r.CPUShares > 0 && (r.CPUShares < 2 || r.CPUShares > 262144) {
+		return fmt.Errorf("--cpu-shares must be between 2 and 262144")
+	}
+	if r.CPUWeight > 10000 {
+		return fmt.Errorf("--cpu-weight must be between 1 and 10000")
+	}
+	if r.CPUShares > 0 && r.CPUWeight > 0 {
+		return fmt.Errorf("--cpu-shares and --cpu-weight cannot be used together")
+	}
+	return nil
+}
+
+// IsUnified 判断宿主机是否使用 cgroup v2 unified 层级
+// 同时挂载了 v1 控制器和 /sys/fs/cgroup/unified 的混合模式按 v1 处理.
+func IsUnified() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(cgroupRoot, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// writeFile 写入 cgroup 的控制文件.
+func writeFile(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("write %s=%s fail err=%s", file, value, err)
+	}
+	return nil
+}
+
+// readFile 读取 cgroup 的控制文件.
+func readFile(dir, file string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// limitString 把限制值转为写入 cgroup 的字符串 小于 0 表示不限制.
+func limitString(v int64) string {
+	if v < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// removeDir 删除 cgroup 目录
+// 容器进程刚退出时 cgroup 可能还没有完全释放 会返回 EBUSY 所以重试几次.
+func removeDir(dir string) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		err = os.Remove(dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("remove cgroup %s fail err=%s", dir, err)
+}
+
+// readUint 读取只包含一个数字的控制文件 max 表示不限制 返回 0.
+func readUint(dir, file string) (uint64, error) {
+	value, err := readFile(dir, file)
+	if err != nil {
+		return 0, err
+	}
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readKeyValues 读取 key value 格式的控制文件 例如 cpu.stat memory.stat.
+func readKeyValues(dir, file string) (map[string]uint64, error) {
+	content, err := readFile(dir, file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, nil
+}
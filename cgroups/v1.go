@@ -0,0 +1,255 @@
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// v1Controllers duoker 使用的 cgroup v1 控制器.
+var v1Controllers = []string{"memory", "cpu", "cpuacct", "cpuset", "pids", "blkio"}
+
+// v1Unlimited v1 中不限制内存时 memory.limit_in_bytes 读出来的是一个接近 int64 最大值的数
+// 大于这个值的都当作不限制.
+const v1Unlimited = 1 << 62
+
+// v1Manager 管理一个容器在 cgroup v1 各个控制器层级下的 cgroup.
+type v1Manager struct {
+	paths map[string]string // 控制器 -> 容器 cgroup 的完整路径
+}
+
+// newV1Manager mounts 为控制器到挂载点的映射
+// cpu 和 cpuacct 通常挂载在一起 此时它们对应同一个目录.
+func newV1Manager(mounts map[string]string, containerName string) *v1Manager {
+	m := &v1Manager{paths: map[string]string{}}
+	for _, c := range v1Controllers {
+		if mnt, ok := mounts[c]; ok {
+			m.paths[c] = filepath.Join(mnt, cgroupParent, containerName)
+		}
+	}
+	return m
+}
+
+// parseV1Mounts 从 /proc/self/mountinfo 中找出 v1 控制器的挂载点
+// 每行的格式为: id parent major:minor root mountpoint options - fstype source superoptions
+// v1 的控制器名写在 superoptions 里 例如 rw,cpu,cpuacct.
+func parseV1Mounts(r io.Reader) (map[string]string, error) {
+	mounts := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fields) < sep+4 || fields[sep+1] != "cgroup" {
+			continue
+		}
+		for _, opt := range strings.Split(fields[sep+3], ",") {
+			for _, c := range v1Controllers {
+				if opt == c {
+					mounts[c] = fields[4]
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("parse mountinfo fail err=%s", err)
+	}
+	return mounts, nil
+}
+
+// dirs 去重后的 cgroup 目录 共同挂载的控制器只返回一次.
+func (m *v1Manager) dirs() []string {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, c := range v1Controllers {
+		dir, ok := m.paths[c]
+		if !ok || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// create 在每个控制器下创建容器的 cgroup.
+func (m *v1Manager) create() error {
+	for _, dir := range m.dirs() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir cgroup %s fail err=%s", dir, err)
+		}
+	}
+	// cpuset 的 cpus 和 mems 为空时不能加入进程 需要从父 cgroup 继承
+	if dir, ok := m.paths["cpuset"]; ok {
+		for _, d := range []string{filepath.Dir(dir), dir} {
+			if err := inheritCpuset(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inheritCpuset cpuset.cpus 和 cpuset.mems 为空时复制父 cgroup 的值.
+func inheritCpuset(dir string) error {
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		value, err := readFile(dir, file)
+		if err != nil {
+			return fmt.Errorf("read %s fail err=%s", file, err)
+		}
+		if value != "" {
+			continue
+		}
+		parent, err := readFile(filepath.Dir(dir), file)
+		if err != nil {
+			return fmt.Errorf("read parent %s fail err=%s", file, err)
+		}
+		if err := writeFile(dir, file, parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *v1Manager) Set(r *Resources) error {
+	if err := m.create(); err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	if dir, ok := m.paths["memory"]; ok {
+		if r.Memory > 0 {
+			if err := writeFile(dir, "memory.limit_in_bytes", strconv.FormatInt(r.Memory, 10)); err != nil {
+				return err
+			}
+		}
+		// v1 的 memsw 和 --memory-swap 含义相同 都是内存加 swap 的总量
+		// 内核没有开启 swap 记账时没有这个文件
+		if r.MemorySwap != 0 {
+			if _, err := os.Stat(filepath.Join(dir, "memory.memsw.limit_in_bytes")); err == nil {
+				if err := writeFile(dir, "memory.memsw.limit_in_bytes", strconv.FormatInt(r.MemorySwap, 10)); err != nil {
+					return err
+				}
+			} else if r.MemorySwap > 0 {
+				return fmt.Errorf("--memory-swap is not supported: swap accounting is disabled")
+			}
+		}
+	} else if r.Memory > 0 || r.MemorySwap != 0 {
+		return fmt.Errorf("cgroup v1 memory controller is not mounted")
+	}
+	if r.CPUs > 0 || r.CPUShares > 0 || r.CPUWeight > 0 {
+		dir, ok := m.paths["cpu"]
+		if !ok {
+			return fmt.Errorf("cgroup v1 cpu controller is not mounted")
+		}
+		if r.CPUs > 0 {
+			if err := writeFile(dir, "cpu.cfs_period_us", strconv.Itoa(cpuPeriod)); err != nil {
+				return err
+			}
+			if err := writeFile(dir, "cpu.cfs_quota_us", strconv.FormatInt(int64(r.CPUs*cpuPeriod), 10)); err != nil {
+				return err
+			}
+		}
+		if shares := r.CPUShares; shares > 0 || r.CPUWeight > 0 {
+			if shares == 0 {
+				shares = weightToShares(r.CPUWeight)
+			}
+			if err := writeFile(dir, "cpu.shares", strconv.FormatInt(shares, 10)); err != nil {
+				return err
+			}
+		}
+	}
+	if r.CPUSetCPUs != "" {
+		dir, ok := m.paths["cpuset"]
+		if !ok {
+			return fmt.Errorf("cgroup v1 cpuset controller is not mounted")
+		}
+		if err := writeFile(dir, "cpuset.cpus", r.CPUSetCPUs); err != nil {
+			return err
+		}
+	}
+	if r.PidsLimit > 0 {
+		dir, ok := m.paths["pids"]
+		if !ok {
+			return fmt.Errorf("cgroup v1 pids controller is not mounted")
+		}
+		if err := writeFile(dir, "pids.max", strconv.FormatInt(r.PidsLimit, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// weightToShares 把 v2 的 cpu.weight [1, 10000] 线性换算为 v1 的 cpu.shares [2, 262144] 是 sharesToWeight 的逆运算.
+func weightToShares(weight int64) int64 {
+	return 2 + ((weight-1)*262142)/9999
+}
+
+func (m *v1Manager) Apply(pid int) error {
+	for _, dir := range m.dirs() {
+		if err := writeFile(dir, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *v1Manager) Stats() (*Stats, error) {
+	stats := &Stats{}
+	var err error
+	if dir, ok := m.paths["cpuacct"]; ok {
+		if stats.CPUUsage, err = readUint(dir, "cpuacct.usage"); err != nil {
+			return nil, fmt.Errorf("read cpuacct.usage fail err=%s", err)
+		}
+	}
+	if dir, ok := m.paths["memory"]; ok {
+		if stats.MemoryUsage, err = readUint(dir, "memory.usage_in_bytes"); err != nil {
+			return nil, fmt.Errorf("read memory.usage_in_bytes fail err=%s", err)
+		}
+		if stats.MemoryLimit, _ = readUint(dir, "memory.limit_in_bytes"); stats.MemoryLimit >= v1Unlimited {
+			stats.MemoryLimit = 0
+		}
+	}
+	if dir, ok := m.paths["pids"]; ok {
+		stats.Pids, _ = readUint(dir, "pids.current")
+		stats.PidsLimit, _ = readUint(dir, "pids.max")
+	}
+	// blkio.throttle.io_service_bytes 每行一项: 8:0 Read 4096
+	if dir, ok := m.paths["blkio"]; ok {
+		if content, err := readFile(dir, "blkio.throttle.io_service_bytes"); err == nil {
+			for _, line := range strings.Split(content, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) != 3 {
+					continue
+				}
+				v, _ := strconv.ParseUint(fields[2], 10, 64)
+				switch fields[1] {
+				case "Read":
+					stats.BlkioRead += v
+				case "Write":
+					stats.BlkioWrite += v
+				}
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (m *v1Manager) Destroy() error {
+	for _, dir := range m.dirs() {
+		if err := removeDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
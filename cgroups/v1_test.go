@@ -0,0 +1,184 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeV1 在临时目录下构造 v1 的控制器挂载点 cpu 和 cpuacct 共用一个层级
+// 真实的 cgroupfs 在 mkdir 时由内核创建控制文件 这里提前创建好.
+func fakeV1(t *testing.T, name string) (map[string]string, *v1Manager) {
+	root := t.TempDir()
+	mounts := map[string]string{
+		"memory":  filepath.Join(root, "memory"),
+		"cpu":     filepath.Join(root, "cpu,cpuacct"),
+		"cpuacct": filepath.Join(root, "cpu,cpuacct"),
+		"cpuset":  filepath.Join(root, "cpuset"),
+		"pids":    filepath.Join(root, "pids"),
+		"blkio":   filepath.Join(root, "blkio"),
+	}
+	for _, mnt := range mounts {
+		for _, dir := range []string{mnt, filepath.Join(mnt, cgroupParent), filepath.Join(mnt, cgroupParent, name)} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cpuset := mounts["cpuset"]
+	writeTestFile(t, cpuset, "cpuset.cpus", "0-3\n")
+	writeTestFile(t, cpuset, "cpuset.mems", "0\n")
+	for _, dir := range []string{filepath.Join(cpuset, cgroupParent), filepath.Join(cpuset, cgroupParent, name)} {
+		writeTestFile(t, dir, "cpuset.cpus", "")
+		writeTestFile(t, dir, "cpuset.mems", "")
+	}
+	return mounts, newV1Manager(mounts, name)
+}
+
+func writeTestFile(t *testing.T, dir, file, value string) {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectFile(t *testing.T, dir, file, want string) {
+	got, err := readFile(dir, file)
+	if err != nil {
+		t.Fatalf("read %s: %s", file, err)
+	}
+	if got != want {
+		t.Fatalf("%s = %q, want %q", file, got, want)
+	}
+}
+
+func TestParseV1Mounts(t *testing.T) {
+	mountinfo := `25 30 0:23 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+33 25 0:28 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:10 - cgroup2 cgroup2 rw,nsdelegate
+34 25 0:29 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,xattr,name=systemd
+37 25 0:32 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:15 - cgroup cgroup rw,cpu,cpuacct
+38 25 0:33 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:16 - cgroup cgroup rw,memory
+39 25 0:34 / /sys/fs/cgroup/pids rw,nosuid,nodev,noexec,relatime shared:17 - cgroup cgroup rw,pids
+`
+	mounts, err := parseV1Mounts(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"cpu":     "/sys/fs/cgroup/cpu,cpuacct",
+		"cpuacct": "/sys/fs/cgroup/cpu,cpuacct",
+		"memory":  "/sys/fs/cgroup/memory",
+		"pids":    "/sys/fs/cgroup/pids",
+	}
+	if len(mounts) != len(want) {
+		t.Fatalf("mounts = %v, want %v", mounts, want)
+	}
+	for c, mnt := range want {
+		if mounts[c] != mnt {
+			t.Fatalf("mount of %s = %q, want %q", c, mounts[c], mnt)
+		}
+	}
+}
+
+func TestV1Set(t *testing.T) {
+	mounts, m := fakeV1(t, "c1")
+	memory := filepath.Join(mounts["memory"], cgroupParent, "c1")
+	writeTestFile(t, memory, "memory.memsw.limit_in_bytes", "")
+	r := &Resources{
+		Memory:     64 << 20,
+		MemorySwap: 128 << 20,
+		CPUs:       1.5,
+		CPUShares:  512,
+		CPUSetCPUs: "1",
+		PidsLimit:  20,
+	}
+	if err := m.Set(r); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, memory, "memory.limit_in_bytes", "67108864")
+	expectFile(t, memory, "memory.memsw.limit_in_bytes", "134217728")
+	cpu := filepath.Join(mounts["cpu"], cgroupParent, "c1")
+	expectFile(t, cpu, "cpu.cfs_period_us", "100000")
+	expectFile(t, cpu, "cpu.cfs_quota_us", "150000")
+	expectFile(t, cpu, "cpu.shares", "512")
+	cpuset := filepath.Join(mounts["cpuset"], cgroupParent, "c1")
+	expectFile(t, cpuset, "cpuset.cpus", "1")
+	expectFile(t, cpuset, "cpuset.mems", "0")
+	expectFile(t, filepath.Dir(cpuset), "cpuset.cpus", "0-3")
+	expectFile(t, filepath.Join(mounts["pids"], cgroupParent, "c1"), "pids.max", "20")
+}
+
+func TestV1SetCPUWeight(t *testing.T) {
+	mounts, m := fakeV1(t, "c1")
+	if err := m.Set(&Resources{CPUWeight: 10000}); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, filepath.Join(mounts["cpu"], cgroupParent, "c1"), "cpu.shares", "262144")
+	if got := weightToShares(1); got != 2 {
+		t.Errorf("weight 1 got shares %d, want 2", got)
+	}
+}
+
+func TestV1SetSwapWithoutAccounting(t *testing.T) {
+	_, m := fakeV1(t, "c1")
+	if err := m.Set(&Resources{Memory: 64 << 20, MemorySwap: 128 << 20}); err == nil {
+		t.Fatal("expect error when memsw is not available")
+	}
+}
+
+func TestV1ApplyAndDestroy(t *testing.T) {
+	mounts, m := fakeV1(t, "c1")
+	if err := m.Apply(1234); err != nil {
+		t.Fatal(err)
+	}
+	// cpu 和 cpuacct 共用的目录只写一次
+	if n := len(m.dirs()); n != 5 {
+		t.Fatalf("dirs = %d, want 5", n)
+	}
+	for _, c := range v1Controllers {
+		expectFile(t, filepath.Join(mounts[c], cgroupParent, "c1"), "cgroup.procs", "1234")
+	}
+	// 真实的 cgroupfs 可以直接 rmdir 这里先删掉控制文件
+	for _, dir := range m.dirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	if err := m.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range m.dirs() {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("expect %s removed", dir)
+		}
+	}
+}
+
+func TestV1Stats(t *testing.T) {
+	mounts, m := fakeV1(t, "c1")
+	dir := func(c string) string { return filepath.Join(mounts[c], cgroupParent, "c1") }
+	writeTestFile(t, dir("cpuacct"), "cpuacct.usage", "123456789\n")
+	writeTestFile(t, dir("memory"), "memory.usage_in_bytes", "1048576\n")
+	writeTestFile(t, dir("memory"), "memory.limit_in_bytes", "9223372036854771712\n")
+	writeTestFile(t, dir("pids"), "pids.current", "3\n")
+	writeTestFile(t, dir("pids"), "pids.max", "max\n")
+	writeTestFile(t, dir("blkio"), "blkio.throttle.io_service_bytes",
+		"8:0 Read 4096\n8:0 Write 8192\n8:0 Sync 0\n8:16 Read 1024\nTotal 13312\n")
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		CPUUsage:    123456789,
+		MemoryUsage: 1048576,
+		MemoryLimit: 0,
+		Pids:        3,
+		PidsLimit:   0,
+		BlkioRead:   5120,
+		BlkioWrite:  8192,
+	}
+	if *stats != want {
+		t.Fatalf("stats = %+v, want %+v", *stats, want)
+	}
+}
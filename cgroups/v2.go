@@ -0,0 +1,159 @@
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// v2Controllers duoker 需要在子 cgroup 中启用的控制器.
+var v2Controllers = []string{"cpu", "cpuset", "memory", "pids", "io"}
+
+// v2Manager 管理一个容器在 cgroup v2 unified 层级下的 cgroup.
+type v2Manager struct {
+	root string // cgroup2 的挂载点
+	path string // 容器 cgroup 的完整路径
+}
+
+func newV2Manager(root, containerName string) *v2Manager {
+	return &v2Manager{
+		root: root,
+		path: filepath.Join(root, cgroupParent, containerName),
+	}
+}
+
+// create 创建容器的 cgroup
+// v2 中子 cgroup 只能使用父 cgroup 在 cgroup.subtree_control 中启用的控制器
+// 所以需要依次在根 cgroup 和 duoker cgroup 中启用.
+func (m *v2Manager) create() error {
+	parent := filepath.Dir(m.path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("mkdir cgroup %s fail err=%s", parent, err)
+	}
+	for _, dir := range []string{m.root, parent} {
+		if err := enableControllers(dir); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(m.path, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("mkdir cgroup %s fail err=%s", m.path, err)
+	}
+	return nil
+}
+
+// enableControllers 在 dir 的 cgroup.subtree_control 中启用可用的控制器.
+func enableControllers(dir string) error {
+	available, err := readFile(dir, "cgroup.controllers")
+	if err != nil {
+		return fmt.Errorf("read cgroup.controllers fail err=%s", err)
+	}
+	var enable []string
+	for _, c := range v2Controllers {
+		for _, a := range strings.Fields(available) {
+			if c == a {
+				enable = append(enable, "+"+c)
+			}
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	return writeFile(dir, "cgroup.subtree_control", strings.Join(enable, " "))
+}
+
+func (m *v2Manager) Set(r *Resources) error {
+	if err := m.create(); err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	if r.Memory > 0 {
+		if err := writeFile(m.path, "memory.max", limitString(r.Memory)); err != nil {
+			return err
+		}
+	}
+	// v2 中 swap 单独限制 而 --memory-swap 和 docker 一样表示内存加 swap 的总量
+	if r.MemorySwap != 0 {
+		swap := int64(-1)
+		if r.MemorySwap > 0 {
+			swap = r.MemorySwap - r.Memory
+		}
+		if err := writeFile(m.path, "memory.swap.max", limitString(swap)); err != nil {
+			return err
+		}
+	}
+	if r.CPUs > 0 {
+		quota := int64(r.CPUs * cpuPeriod)
+		if err := writeFile(m.path, "cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			return err
+		}
+	}
+	if weight := r.CPUWeight; weight > 0 || r.CPUShares > 0 {
+		if weight == 0 {
+			weight = sharesToWeight(r.CPUShares)
+		}
+		if err := writeFile(m.path, "cpu.weight", strconv.FormatInt(weight, 10)); err != nil {
+			return err
+		}
+	}
+	if r.CPUSetCPUs != "" {
+		if err := writeFile(m.path, "cpuset.cpus", r.CPUSetCPUs); err != nil {
+			return err
+		}
+	}
+	if r.PidsLimit > 0 {
+		if err := writeFile(m.path, "pids.max", limitString(r.PidsLimit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sharesToWeight 把 v1 的 cpu.shares [2, 262144] 线性换算为 v2 的 cpu.weight [1, 10000].
+func sharesToWeight(shares int64) int64 {
+	return 1 + ((shares-2)*9999)/262142
+}
+
+func (m *v2Manager) Apply(pid int) error {
+	return writeFile(m.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+func (m *v2Manager) Stats() (*Stats, error) {
+	stats := &Stats{}
+	cpu, err := readKeyValues(m.path, "cpu.stat")
+	if err != nil {
+		return nil, fmt.Errorf("read cpu.stat fail err=%s", err)
+	}
+	stats.CPUUsage = cpu["usage_usec"] * 1000
+	if stats.MemoryUsage, err = readUint(m.path, "memory.current"); err != nil {
+		return nil, fmt.Errorf("read memory.current fail err=%s", err)
+	}
+	// 没有启用的控制器对应的文件不存在 忽略即可
+	stats.MemoryLimit, _ = readUint(m.path, "memory.max")
+	stats.Pids, _ = readUint(m.path, "pids.current")
+	stats.PidsLimit, _ = readUint(m.path, "pids.max")
+	// io.stat 每行一个设备: 8:0 rbytes=1 wbytes=2 rios=3 wios=4 ...
+	if content, err := readFile(m.path, "io.stat"); err == nil {
+		for _, field := range strings.Fields(content) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				stats.BlkioRead += v
+			case "wbytes":
+				stats.BlkioWrite += v
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (m *v2Manager) Destroy() error {
+	return removeDir(m.path)
+}
@@ -0,0 +1,99 @@
+package cgroups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeV2 在临时目录下构造 unified 层级.
+func fakeV2(t *testing.T, name string) (string, *v2Manager) {
+	root := t.TempDir()
+	writeTestFile(t, root, "cgroup.controllers", "cpuset cpu io memory hugetlb pids\n")
+	parent := filepath.Join(root, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, parent, "cgroup.controllers", "cpuset cpu memory pids\n")
+	return root, newV2Manager(root, name)
+}
+
+func TestV2Set(t *testing.T) {
+	root, m := fakeV2(t, "c1")
+	r := &Resources{
+		Memory:     64 << 20,
+		MemorySwap: 128 << 20,
+		CPUs:       0.5,
+		CPUShares:  1024,
+		CPUSetCPUs: "0-1",
+		PidsLimit:  20,
+	}
+	if err := m.Set(r); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, root, "cgroup.subtree_control", "+cpu +cpuset +memory +pids +io")
+	expectFile(t, filepath.Join(root, cgroupParent), "cgroup.subtree_control", "+cpu +cpuset +memory +pids")
+	dir := filepath.Join(root, cgroupParent, "c1")
+	expectFile(t, dir, "memory.max", "67108864")
+	expectFile(t, dir, "memory.swap.max", "67108864")
+	expectFile(t, dir, "cpu.max", "50000 100000")
+	expectFile(t, dir, "cpu.weight", "39")
+	expectFile(t, dir, "cpuset.cpus", "0-1")
+	expectFile(t, dir, "pids.max", "20")
+}
+
+func TestV2SetCPUWeight(t *testing.T) {
+	root, m := fakeV2(t, "c1")
+	if err := m.Set(&Resources{CPUWeight: 500}); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, filepath.Join(root, cgroupParent, "c1"), "cpu.weight", "500")
+}
+
+func TestV2SetUnlimitedSwap(t *testing.T) {
+	root, m := fakeV2(t, "c1")
+	if err := m.Set(&Resources{Memory: 64 << 20, MemorySwap: -1}); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, filepath.Join(root, cgroupParent, "c1"), "memory.swap.max", "max")
+}
+
+func TestV2Apply(t *testing.T) {
+	root, m := fakeV2(t, "c1")
+	if err := m.Set(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(1234); err != nil {
+		t.Fatal(err)
+	}
+	expectFile(t, filepath.Join(root, cgroupParent, "c1"), "cgroup.procs", "1234")
+}
+
+func TestV2Stats(t *testing.T) {
+	root, m := fakeV2(t, "c1")
+	dir := filepath.Join(root, cgroupParent, "c1")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "cpu.stat", "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n")
+	writeTestFile(t, dir, "memory.current", "2097152\n")
+	writeTestFile(t, dir, "memory.max", "67108864\n")
+	writeTestFile(t, dir, "pids.current", "2\n")
+	writeTestFile(t, dir, "pids.max", "max\n")
+	writeTestFile(t, dir, "io.stat", "8:0 rbytes=4096 wbytes=1024 rios=1 wios=1 dbytes=0 dios=0\n8:16 rbytes=100 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n")
+	stats, err := m.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		CPUUsage:    2500000,
+		MemoryUsage: 2097152,
+		MemoryLimit: 67108864,
+		Pids:        2,
+		BlkioRead:   4196,
+		BlkioWrite:  1024,
+	}
+	if *stats != want {
+		t.Fatalf("stats = %+v, want %+v", *stats, want)
+	}
+}
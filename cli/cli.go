@@ -0,0 +1,248 @@
+// Package cli 命令行的子命令分发 帮助 版本和补全
+// 每个子命令自己用 FlagSet 解析参数 cli 只负责找到子命令并统一处理错误和退出码.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// 进程的退出码.
+const (
+	ExitOK      = 0 // 成功
+	ExitFailure = 1 // 子命令执行失败
+	ExitUsage   = 2 // 参数错误 和 flag 包解析失败时的退出码一致
+)
+
+// Command 一个子命令.
+type Command struct {
+	Name        string                    // 子命令名
+	Short       string                    // 一句话说明 显示在帮助中
+	Hidden      bool                      // 内部使用的子命令 不显示在帮助和补全中 例如 init
+	Subcommands []string                  // 二级子命令 只用于补全 例如 volume ls
+	Run         func(args []string) error // 执行子命令 args 不包括子命令名
+}
+
+// App 命令行程序.
+type App struct {
+	Name     string
+	Version  string
+	Short    string // 程序的一句话说明
+	Commands []*Command
+	Stdout   io.Writer
+	Stderr   io.Writer
+	// OnError 子命令返回错误时调用 为空时把错误写到 Stderr.
+	OnError func(cmd *Command, err error)
+}
+
+// UsageError 子命令的参数不正确 退出码为 ExitUsage.
+type UsageError struct {
+	Usage string // 不包括程序名的用法 例如 rm [-f] NAME...
+}
+
+func (e *UsageError) Error() string {
+	return "usage: " + e.Usage
+}
+
+// ExitError 子命令需要以指定的退出码退出 例如 exec 返回容器内命令的退出码
+// Err 不为空时同时报告错误.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// FlagSet 子命令的参数 在 flag.FlagSet 的基础上记录了子命令的用法
+// -h 或 --help 时打印用法和参数说明 解析失败时以 ExitUsage 退出.
+type FlagSet struct {
+	*flag.FlagSet
+	usage string
+}
+
+// NewFlagSet 创建子命令的 FlagSet usage 为不包括程序名的用法 例如 rm [-f] NAME....
+func NewFlagSet(name, usage string) *FlagSet {
+	fs := &FlagSet{FlagSet: flag.NewFlagSet(name, flag.ExitOnError), usage: usage}
+	fs.FlagSet.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s\n", programName(), usage)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nOptions:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// UsageError 返回参数数量等不正确时的错误.
+func (fs *FlagSet) UsageError() error {
+	return &UsageError{Usage: fs.usage}
+}
+
+// Usagef 返回没有使用 FlagSet 的子命令的参数错误.
+func Usagef(format string, a ...interface{}) error {
+	return &UsageError{Usage: fmt.Sprintf(format, a...)}
+}
+
+// programName 用法中显示的程序名.
+func programName() string {
+	if len(os.Args) > 0 {
+		if i := strings.LastIndexByte(os.Args[0], '/'); i >= 0 {
+			return os.Args[0][i+1:]
+		}
+		return os.Args[0]
+	}
+	return "duoker"
+}
+
+// Run 解析命令行并执行子命令 返回进程的退出码 args 不包括程序名
+// 没有参数时打印帮助并返回 ExitUsage.
+func (a *App) Run(args []string) int {
+	if len(args) == 0 {
+		a.printHelp(a.stderr())
+		return ExitUsage
+	}
+	switch args[0] {
+	case "-h", "--help":
+		a.printHelp(a.stdout())
+		return ExitOK
+	case "-v", "--version":
+		a.printVersion()
+		return ExitOK
+	}
+	cmd := a.lookup(args[0])
+	if cmd == nil {
+		fmt.Fprintf(a.stderr(), "%s: unknown command %q\nRun '%s --help' for usage.\n", a.Name, args[0], a.Name)
+		return ExitUsage
+	}
+	err := cmd.Run(args[1:])
+	if err == nil {
+		return ExitOK
+	}
+	var usageErr *UsageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(a.stderr(), usageErr.Error())
+		if !cmd.Hidden {
+			fmt.Fprintf(a.stderr(), "Run '%s %s --help' for more information.\n", a.Name, cmd.Name)
+		}
+		return ExitUsage
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Err != nil {
+			a.reportError(cmd, exitErr.Err)
+		}
+		return exitErr.Code
+	}
+	a.reportError(cmd, err)
+	return ExitFailure
+}
+
+// lookup 按名字查找子命令 包括内置的 help version completion.
+func (a *App) lookup(name string) *Command {
+	for _, cmd := range a.commands() {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// commands 所有子命令 内置的子命令在最后.
+func (a *App) commands() []*Command {
+	builtins := []*Command{
+		{Name: "help", Short: "Show help for a command", Run: a.help},
+		{Name: "version", Short: "Show the version information", Run: func(args []string) error {
+			a.printVersion()
+			return nil
+		}},
+		{Name: "completion", Short: "Generate the shell completion script",
+			Subcommands: completionShells, Run: a.completion},
+	}
+	return append(append([]*Command(nil), a.Commands...), builtins...)
+}
+
+// help 没有参数时打印程序的帮助 否则打印子命令的帮助.
+func (a *App) help(args []string) error {
+	if len(args) == 0 {
+		a.printHelp(a.stdout())
+		return nil
+	}
+	cmd := a.lookup(args[0])
+	if cmd == nil {
+		return Usagef("help [COMMAND]")
+	}
+	// 子命令的 FlagSet 处理 --help 打印用法后退出
+	return cmd.Run([]string{"--help"})
+}
+
+// printHelp 打印程序的用法和所有可见的子命令.
+func (a *App) printHelp(out io.Writer) {
+	fmt.Fprintf(out, "Usage: %s COMMAND [ARG...]\n\n", a.Name)
+	if a.Short != "" {
+		fmt.Fprintf(out, "%s\n\n", a.Short)
+	}
+	fmt.Fprintln(out, "Commands:")
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	for _, cmd := range a.visibleCommands() {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.Name, cmd.Short)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "\nOptions:\n  -h, --help      Show this help\n  -v, --version   Show the version information\n")
+	fmt.Fprintf(out, "\nRun '%s COMMAND --help' for more information on a command.\n", a.Name)
+}
+
+// visibleCommands 显示在帮助和补全中的子命令.
+func (a *App) visibleCommands() []*Command {
+	var visible []*Command
+	for _, cmd := range a.commands() {
+		if !cmd.Hidden {
+			visible = append(visible, cmd)
+		}
+	}
+	return visible
+}
+
+// printVersion 打印版本号.
+func (a *App) printVersion() {
+	fmt.Fprintf(a.stdout(), "%s version %s\n", a.Name, a.Version)
+}
+
+// reportError 报告子命令的错误.
+func (a *App) reportError(cmd *Command, err error) {
+	if a.OnError != nil {
+		a.OnError(cmd, err)
+		return
+	}
+	fmt.Fprintf(a.stderr(), "%s %s: %s\n", a.Name, cmd.Name, err)
+}
+
+func (a *App) stdout() io.Writer {
+	if a.Stdout != nil {
+		return a.Stdout
+	}
+	return os.Stdout
+}
+
+func (a *App) stderr() io.Writer {
+	if a.Stderr != nil {
+		return a.Stderr
+	}
+	return os.Stderr
+}
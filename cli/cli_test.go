@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestApp 创建输出写到缓冲区的 App 记录子命令收到的参数.
+func newTestApp(got *[]string) (*App, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	app := &App{
+		Name:    "duoker",
+		Version: "v1.2.3",
+		Commands: []*Command{
+			{Name: "run", Short: "Create and run a new container", Run: func(args []string) error {
+				*got = args
+				return nil
+			}},
+			{Name: "fail", Short: "Always fail", Run: func(args []string) error {
+				return errors.New("boom")
+			}},
+			{Name: "usage", Short: "Need arguments", Run: func(args []string) error {
+				return Usagef("usage NAME")
+			}},
+			{Name: "exit", Short: "Exit with a code", Run: func(args []string) error {
+				return &ExitError{Code: 42}
+			}},
+			{Name: "volume", Short: "Manage volumes", Subcommands: []string{"create", "ls"}, Run: func(args []string) error {
+				return nil
+			}},
+			{Name: "init", Hidden: true, Run: func(args []string) error {
+				*got = append([]string{"init"}, args...)
+				return nil
+			}},
+		},
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	return app, stdout, stderr
+}
+
+func TestRunDispatch(t *testing.T) {
+	var got []string
+	app, _, _ := newTestApp(&got)
+	if code := app.Run([]string{"run", "-d", "ubuntu", "c1", "sh"}); code != ExitOK {
+		t.Fatalf("run: exit code %d", code)
+	}
+	if want := []string{"-d", "ubuntu", "c1", "sh"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("run: got args %v, want %v", got, want)
+	}
+	// 隐藏的子命令仍然可以执行
+	if code := app.Run([]string{"init", "c1", "/bin/sh"}); code != ExitOK || got[0] != "init" {
+		t.Errorf("init: exit code %d args %v", code, got)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stderr string
+	}{
+		{nil, ExitUsage, "Usage: duoker COMMAND"},
+		{[]string{"nosuch"}, ExitUsage, `unknown command "nosuch"`},
+		{[]string{"--nosuch"}, ExitUsage, `unknown command "--nosuch"`},
+		{[]string{"fail"}, ExitFailure, "duoker fail: boom"},
+		{[]string{"usage"}, ExitUsage, "usage: usage NAME"},
+		{[]string{"exit"}, 42, ""},
+		{[]string{"completion", "tcsh"}, ExitUsage, "usage: completion bash|zsh|fish"},
+	}
+	for _, tt := range tests {
+		var got []string
+		app, _, stderr := newTestApp(&got)
+		if code := app.Run(tt.args); code != tt.code {
+			t.Errorf("%v: got exit code %d, want %d", tt.args, code, tt.code)
+		}
+		if !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("%v: stderr %q does not contain %q", tt.args, stderr.String(), tt.stderr)
+		}
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var got []string
+	app, _, _ := newTestApp(&got)
+	var reported string
+	app.OnError = func(cmd *Command, err error) {
+		reported = cmd.Name + ": " + err.Error()
+	}
+	app.Run([]string{"fail"})
+	if reported != "fail: boom" {
+		t.Errorf("got reported %q", reported)
+	}
+	// exec 的命令执行失败时 错误和退出码都要保留
+	app.Commands[0].Run = func(args []string) error {
+		return &ExitError{Code: 127, Err: errors.New("not found")}
+	}
+	if code := app.Run([]string{"run"}); code != 127 || reported != "run: not found" {
+		t.Errorf("got exit code %d reported %q", code, reported)
+	}
+}
+
+func TestHelpAndVersion(t *testing.T) {
+	for _, args := range [][]string{{"--help"}, {"-h"}, {"help"}} {
+		var got []string
+		app, stdout, _ := newTestApp(&got)
+		if code := app.Run(args); code != ExitOK {
+			t.Fatalf("%v: exit code %d", args, code)
+		}
+		help := stdout.String()
+		for _, want := range []string{"run", "Create and run a new container", "volume", "completion", "version"} {
+			if !strings.Contains(help, want) {
+				t.Errorf("%v: help does not contain %q:\n%s", args, want, help)
+			}
+		}
+		if strings.Contains(help, "init") {
+			t.Errorf("%v: help shows hidden command:\n%s", args, help)
+		}
+	}
+	for _, args := range [][]string{{"--version"}, {"-v"}, {"version"}} {
+		var got []string
+		app, stdout, _ := newTestApp(&got)
+		if code := app.Run(args); code != ExitOK || stdout.String() != "duoker version v1.2.3\n" {
+			t.Errorf("%v: exit code %d output %q", args, code, stdout.String())
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range completionShells {
+		var got []string
+		app, stdout, _ := newTestApp(&got)
+		if code := app.Run([]string{"completion", shell}); code != ExitOK {
+			t.Fatalf("%s: exit code %d", shell, code)
+		}
+		script := stdout.String()
+		for _, want := range []string{"run", "volume", "create", "completion"} {
+			if !strings.Contains(script, want) {
+				t.Errorf("%s: script does not contain %q:\n%s", shell, want, script)
+			}
+		}
+		if strings.Contains(script, "init") {
+			t.Errorf("%s: script completes hidden command:\n%s", shell, script)
+		}
+	}
+}
+
+func TestFlagSet(t *testing.T) {
+	fs := NewFlagSet("rm", "rm [-f] NAME...")
+	force := fs.Bool("f", false, "force")
+	if err := fs.Parse([]string{"-f", "c1", "c2"}); err != nil {
+		t.Fatal(err)
+	}
+	if !*force || !reflect.DeepEqual(fs.Args(), []string{"c1", "c2"}) {
+		t.Errorf("got force=%v args=%v", *force, fs.Args())
+	}
+	var usageErr *UsageError
+	if err := fs.UsageError(); !errors.As(err, &usageErr) || err.Error() != "usage: rm [-f] NAME..." {
+		t.Errorf("got usage error %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// completionShells 支持生成补全脚本的 shell.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completion 生成 shell 的补全脚本 补全子命令和二级子命令 其余参数补全文件名
+// 例如 source <(duoker completion bash).
+func (a *App) completion(args []string) error {
+	usage := Usagef("completion %s", strings.Join(completionShells, "|"))
+	if len(args) != 1 {
+		return usage
+	}
+	switch args[0] {
+	case "bash":
+		a.bashCompletion(a.stdout())
+	case "zsh":
+		a.zshCompletion(a.stdout())
+	case "fish":
+		a.fishCompletion(a.stdout())
+	default:
+		return usage
+	}
+	return nil
+}
+
+// commandNames 可见子命令的名字.
+func (a *App) commandNames() []string {
+	var names []string
+	for _, cmd := range a.visibleCommands() {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
+
+func (a *App) bashCompletion(out io.Writer) {
+	fn := "_" + a.Name
+	fmt.Fprintf(out, "# bash completion for %s\n", a.Name)
+	fmt.Fprintf(out, "%s() {\n", fn)
+	fmt.Fprintf(out, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(out, "\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(out, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(a.commandNames(), " "))
+	fmt.Fprintf(out, "\t\treturn\n\tfi\n")
+	fmt.Fprintf(out, "\tif [ \"$COMP_CWORD\" -eq 2 ]; then\n")
+	fmt.Fprintf(out, "\t\tcase \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range a.visibleCommands() {
+		if len(cmd.Subcommands) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\t\t%s)\n", cmd.Name)
+		fmt.Fprintf(out, "\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(cmd.Subcommands, " "))
+		fmt.Fprintf(out, "\t\t\treturn\n\t\t\t;;\n")
+	}
+	fmt.Fprintf(out, "\t\tesac\n\tfi\n")
+	fmt.Fprintf(out, "\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(out, "}\n")
+	fmt.Fprintf(out, "complete -o filenames -F %s %s\n", fn, a.Name)
+}
+
+func (a *App) zshCompletion(out io.Writer) {
+	fn := "_" + a.Name
+	fmt.Fprintf(out, "#compdef %s\n\n", a.Name)
+	fmt.Fprintf(out, "%s() {\n", fn)
+	fmt.Fprintf(out, "\tlocal -a commands\n\tcommands=(\n")
+	for _, cmd := range a.visibleCommands() {
+		fmt.Fprintf(out, "\t\t%s\n", zshQuote(cmd.Name+":"+cmd.Short))
+	}
+	fmt.Fprintf(out, "\t)\n")
+	fmt.Fprintf(out, "\tif (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(out, "\t\t_describe 'command' commands\n")
+	fmt.Fprintf(out, "\t\treturn\n\tfi\n")
+	fmt.Fprintf(out, "\tif (( CURRENT == 3 )); then\n")
+	fmt.Fprintf(out, "\t\tcase \"$words[2]\" in\n")
+	for _, cmd := range a.visibleCommands() {
+		if len(cmd.Subcommands) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\t\t%s)\n", cmd.Name)
+		fmt.Fprintf(out, "\t\t\tcompadd -- %s\n", strings.Join(cmd.Subcommands, " "))
+		fmt.Fprintf(out, "\t\t\treturn\n\t\t\t;;\n")
+	}
+	fmt.Fprintf(out, "\t\tesac\n\tfi\n")
+	fmt.Fprintf(out, "\t_files\n")
+	fmt.Fprintf(out, "}\n\n")
+	// 作为补全函数文件自动加载时直接执行 通过 source 加载时注册
+	fmt.Fprintf(out, "if [ \"$funcstack[1]\" = %q ]; then\n", fn)
+	fmt.Fprintf(out, "\t%s \"$@\"\nelse\n\tcompdef %s %s\nfi\n", fn, fn, a.Name)
+}
+
+func (a *App) fishCompletion(out io.Writer) {
+	fmt.Fprintf(out, "# fish completion for %s\n", a.Name)
+	fmt.Fprintf(out, "complete -c %s -f\n", a.Name)
+	for _, cmd := range a.visibleCommands() {
+		fmt.Fprintf(out, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n",
+			a.Name, cmd.Name, fishQuote(cmd.Short))
+	}
+	for _, cmd := range a.visibleCommands() {
+		if len(cmd.Subcommands) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "complete -c %s -n '__fish_seen_subcommand_from %s' -a %s\n",
+			a.Name, cmd.Name, fishQuote(strings.Join(cmd.Subcommands, " ")))
+	}
+}
+
+// zshQuote 用单引号包裹 字符串中的单引号先结束引号 再转义 再重新开始引号.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote fish 的单引号中可以用 \' 转义单引号.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -8,6 +8,12 @@ import (
 const (
 	IpAmStorageFsPath = "/workplace/duoker/netconfig/subnet.json"
 	NetStoragePath    = "/workplace/duoker/netconfig/network.json"
+	// ContainerStoragePath 每个容器在这里有一个以容器名命名的状态目录
+	ContainerStoragePath = "/workplace/duoker/containers"
+	// ImageStorePath 镜像仓库 每个镜像在这里有一个以镜像名命名的目录
+	ImageStorePath = "/workplace/duoker/images"
+	// VolumeStoragePath 命名卷 每个卷在这里有一个以卷名命名的目录
+	VolumeStoragePath = "/workplace/duoker/volumes"
 )
 
 func Banner() string {
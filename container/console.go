@@ -0,0 +1,111 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Console 前台运行容器时分配的伪终端
+// 容器进程使用 Slave 作为标准输入输出 父进程通过 Master 转发终端的输入输出 同时记录日志
+// 伪终端在容器内分配 Master 通过同步管道交给父进程 父进程中的 Slave 为空.
+type Console struct {
+	Master *os.File
+	Slave  *os.File
+}
+
+func ioctl(fd uintptr, req uintptr, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// NewConsole 通过 /dev/ptmx 分配一对伪终端
+// 在容器内调用时 /dev/ptmx 指向容器自己的 devpts 实例.
+func NewConsole() (*Console, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open ptmx fail err=%s", err)
+	}
+	// unlockpt
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlock pty fail err=%s", err)
+	}
+	// ptsname
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("get pty number fail err=%s", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("open pty slave fail err=%s", err)
+	}
+	return &Console{Master: master, Slave: slave}, nil
+}
+
+// AttachSlave 把 slave 设为当前进程的标准输入输出和控制终端
+// 调用进程需要是会话首进程 并且没有控制终端.
+func (c *Console) AttachSlave() error {
+	for fd := 0; fd < 3; fd++ {
+		if err := syscall.Dup3(int(c.Slave.Fd()), fd, 0); err != nil {
+			return fmt.Errorf("dup pty slave fail err=%s", err)
+		}
+	}
+	if err := ioctl(0, syscall.TIOCSCTTY, 0); err != nil {
+		return fmt.Errorf("set controlling terminal fail err=%s", err)
+	}
+	return nil
+}
+
+// winsize 对应内核的 struct winsize.
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+// ResizeFrom 把终端 f 的窗口大小同步给伪终端.
+func (c *Console) ResizeFrom(f *os.File) error {
+	ws := &winsize{}
+	if err := ioctl(f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(ws))); err != nil {
+		return err
+	}
+	return ioctl(c.Master.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}
+
+// Close 关闭伪终端的两端.
+func (c *Console) Close() error {
+	if c.Slave != nil {
+		c.Slave.Close()
+	}
+	return c.Master.Close()
+}
+
+// SetRawTerminal 把终端设置为 raw 模式 按键直接交给容器内的程序处理
+// 返回恢复终端设置的函数.
+func SetRawTerminal(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, fmt.Errorf("get termios fail err=%s", err)
+	}
+	raw := old
+	// 等价于 cfmakeraw
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, fmt.Errorf("set raw termios fail err=%s", err)
+	}
+	return func() {
+		ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
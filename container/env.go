@@ -0,0 +1,115 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultPath 容器内默认的 PATH 和 docker 一样.
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ParseEnv 解析 run -e 的参数 KEY=VALUE
+// 只有 KEY 时和 docker 一样取宿主机上同名变量的值 宿主机上没有时返回空.
+func ParseEnv(spec string) (string, error) {
+	key := spec
+	if i := strings.IndexByte(spec, '='); i >= 0 {
+		key = spec[:i]
+	}
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", fmt.Errorf("invalid environment variable %q", spec)
+	}
+	if key == spec {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return "", nil
+		}
+		return key + "=" + value, nil
+	}
+	return spec, nil
+}
+
+// ReadEnvFile 读取 --env-file 指定的文件 每行一个 KEY=VALUE 跳过空行和以 # 开头的注释.
+func ReadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open env file %s fail err=%s", path, err)
+	}
+	defer f.Close()
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv, err := ParseEnv(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, n, err)
+		}
+		if kv != "" {
+			env = append(env, kv)
+		}
+	}
+	return env, scanner.Err()
+}
+
+// BuildEnv 生成容器进程的环境变量 不继承宿主机的环境变量
+// 依次为默认的 PATH HOSTNAME (TERM) 镜像中的 Env --env-file 和 -e 后面的覆盖前面的.
+func BuildEnv(hostname string, tty bool, imageEnv, envFiles, envs []string) ([]string, error) {
+	defaults := []string{"PATH=" + DefaultPath, "HOSTNAME=" + hostname}
+	if tty {
+		defaults = append(defaults, "TERM=xterm")
+	}
+	layers := [][]string{defaults, imageEnv}
+	for _, path := range envFiles {
+		env, err := ReadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, env)
+	}
+	var flagEnv []string
+	for _, spec := range envs {
+		kv, err := ParseEnv(spec)
+		if err != nil {
+			return nil, err
+		}
+		if kv != "" {
+			flagEnv = append(flagEnv, kv)
+		}
+	}
+	return MergeEnv(append(layers, flagEnv)...), nil
+}
+
+// MergeEnv 按顺序合并环境变量 后面的同名变量覆盖前面的 位置保持第一次出现的位置.
+func MergeEnv(envs ...[]string) []string {
+	var merged []string
+	index := map[string]int{}
+	for _, env := range envs {
+		for _, kv := range env {
+			key := kv
+			if i := strings.IndexByte(kv, '='); i >= 0 {
+				key = kv[:i]
+			}
+			if i, ok := index[key]; ok {
+				merged[i] = kv
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// LookupEnv 在环境变量列表中查找 key 的值.
+func LookupEnv(env []string, key string) (string, bool) {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:], true
+		}
+	}
+	return "", false
+}
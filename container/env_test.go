@@ -0,0 +1,103 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	t.Setenv("DUOKER_TEST_HOST", "from-host")
+	os.Unsetenv("DUOKER_TEST_MISSING")
+	tests := map[string]string{
+		"FOO=bar":             "FOO=bar",
+		"FOO=":                "FOO=",
+		"FOO=a=b":             "FOO=a=b",
+		"DUOKER_TEST_HOST":    "DUOKER_TEST_HOST=from-host",
+		"DUOKER_TEST_MISSING": "",
+	}
+	for spec, want := range tests {
+		got, err := ParseEnv(spec)
+		if err != nil {
+			t.Fatalf("%q: %s", spec, err)
+		}
+		if got != want {
+			t.Errorf("%q: got %q, want %q", spec, got, want)
+		}
+	}
+	for _, spec := range []string{"", "=bar", "MY VAR=1"} {
+		if _, err := ParseEnv(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	t.Setenv("DUOKER_TEST_HOST", "from-host")
+	path := filepath.Join(t.TempDir(), "env")
+	os.WriteFile(path, []byte("# comment\n\nFOO=bar\n  BAZ=a b \nDUOKER_TEST_HOST\n"), 0644)
+	got, err := ReadEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"FOO=bar", "BAZ=a b ", "DUOKER_TEST_HOST=from-host"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	os.WriteFile(path, []byte("FOO=bar\n=oops\n"), 0644)
+	if _, err := ReadEnvFile(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want line 2", err)
+	}
+	if _, err := ReadEnvFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestBuildEnv(t *testing.T) {
+	t.Setenv("DUOKER_TEST_HOST", "from-host")
+	path := filepath.Join(t.TempDir(), "env")
+	os.WriteFile(path, []byte("FILE=1\nIMAGE=file\nFLAG=file\n"), 0644)
+
+	got, err := BuildEnv("web", true,
+		[]string{"PATH=/usr/bin:/bin", "IMAGE=image"},
+		[]string{path},
+		[]string{"FLAG=flag", "DUOKER_TEST_HOST", "HOSTNAME=override"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 默认值 < 镜像 < --env-file < -e 位置保持第一次出现的位置
+	want := []string{
+		"PATH=/usr/bin:/bin",
+		"HOSTNAME=override",
+		"TERM=xterm",
+		"IMAGE=file",
+		"FILE=1",
+		"FLAG=flag",
+		"DUOKER_TEST_HOST=from-host",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, err = BuildEnv("web", false, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"PATH=" + DefaultPath, "HOSTNAME=web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := BuildEnv("web", false, nil, nil, []string{"=x"}); err == nil {
+		t.Error("expected error for invalid -e")
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	got := MergeEnv([]string{"PATH=/bin", "HOSTNAME=a"}, []string{"FOO=1", "PATH=/usr/bin"})
+	want := []string{"PATH=/usr/bin", "HOSTNAME=a", "FOO=1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	LogFileName        = "container.log"  // 容器输出的日志文件 位于容器状态目录下
+	DefaultLogMaxSize  = 10 * 1024 * 1024 // 单个日志文件默认最大 10MiB
+	DefaultLogMaxFiles = 3                // 默认最多保留 3 个日志文件 包括当前正在写的
+	maxLogLineSize     = 16 * 1024        // 没有换行的超长输出按这个长度切分
+)
+
+// LogEntry 日志文件中的一行.
+type LogEntry struct {
+	Stream string    `json:"stream"` // stdout 或 stderr
+	Time   time.Time `json:"time"`   // 输出的时间
+	Log    string    `json:"log"`    // 输出的内容 包含末尾的换行
+}
+
+// LogPath 容器日志文件的路径.
+func LogPath(name string) string {
+	return filepath.Join(Dir(name), LogFileName)
+}
+
+// LogFile 按行写入 JSON 格式日志的文件
+// 超过 maxSize 时进行轮转 container.log -> container.log.1 -> container.log.2 ...
+type LogFile struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+// OpenLogFile 打开 (追加写) 容器的日志文件.
+func OpenLogFile(path string, maxSize int64, maxFiles int) (*LogFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultLogMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultLogMaxFiles
+	}
+	l := &LogFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("open log file fail err=%s", err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, stat.Size()
+	return nil
+}
+
+// rotatedPath 第 n 个轮转出去的日志文件.
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotate 轮转日志文件 最旧的文件被删除.
+func (l *LogFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.maxFiles > 1 {
+		os.Remove(rotatedPath(l.path, l.maxFiles-1))
+		for n := l.maxFiles - 2; n >= 1; n-- {
+			os.Rename(rotatedPath(l.path, n), rotatedPath(l.path, n+1))
+		}
+		if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+			return fmt.Errorf("rotate log file fail err=%s", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("rotate log file fail err=%s", err)
+	}
+	return l.open()
+}
+
+// WriteEntry 写入一条日志.
+func (l *LogFile) WriteEntry(entry *LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// Close 关闭日志文件.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Stream 返回写入某个输出流的 Writer
+// 按行切分后写入日志 使用完需要 Close 把最后不完整的一行也写进去.
+func (l *LogFile) Stream(stream string) io.WriteCloser {
+	return &streamWriter{file: l, stream: stream}
+}
+
+// streamWriter 把连续的输出按行切分成日志条目.
+type streamWriter struct {
+	file   *LogFile
+	stream string
+	buf    []byte
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 && len(w.buf) < maxLogLineSize {
+			break
+		}
+		n := i + 1
+		if i < 0 {
+			n = lineCut(w.buf)
+		}
+		if err := w.emit(w.buf[:n]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[n:]
+	}
+	return len(p), nil
+}
+
+// lineCut 超长的行在 maxLogLineSize 处切分 切点落在多字节字符中间时往前移到字符的开头
+// 否则两边都会被 json 编码为 U+FFFD 不是合法 UTF-8 的内容仍在 maxLogLineSize 处切分.
+func lineCut(buf []byte) int {
+	for n := maxLogLineSize; n > maxLogLineSize-utf8.UTFMax; n-- {
+		if utf8.RuneStart(buf[n]) {
+			return n
+		}
+	}
+	return maxLogLineSize
+}
+
+func (w *streamWriter) emit(line []byte) error {
+	return w.file.WriteEntry(&LogEntry{Stream: w.stream, Time: time.Now().UTC(), Log: string(line)})
+}
+
+// Close 写入缓冲区中剩余的内容.
+func (w *streamWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.emit(w.buf)
+	w.buf = nil
+	return err
+}
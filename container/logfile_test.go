@@ -0,0 +1,68 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFileRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LogFileName)
+	lf, err := OpenLogFile(path, 200, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := lf.Stream("stdout")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	fmt.Fprint(w, "partial")
+	w.Close()
+	lf.Close()
+
+	if _, err := os.Stat(rotatedPath(path, 2)); err != nil {
+		t.Fatalf("expect rotated file: %s", err)
+	}
+	if _, err := os.Stat(rotatedPath(path, 3)); !os.IsNotExist(err) {
+		t.Fatalf("expect at most 3 log files")
+	}
+	entries, err := readLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := entries[len(entries)-1]
+	if last.Stream != "stdout" || last.Log != "partial" {
+		t.Fatalf("unexpected last entry %+v", last)
+	}
+}
+
+func TestStreamWriterSplitRune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LogFileName)
+	lf, err := OpenLogFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 字节的字符跨过 maxLogLineSize
+	line := strings.Repeat("a", maxLogLineSize-1) + "中文\n"
+	w := lf.Stream("stdout")
+	fmt.Fprint(w, line[:len(line)-1])
+	fmt.Fprint(w, "\n")
+	w.Close()
+	lf.Close()
+
+	entries, err := readLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if got := entries[0].Log + entries[1].Log; got != line {
+		t.Fatalf("line changed after split: %q", got[len(got)-10:])
+	}
+	if len(entries[0].Log) != maxLogLineSize-1 {
+		t.Fatalf("got first entry of %d bytes, want %d", len(entries[0].Log), maxLogLineSize-1)
+	}
+}
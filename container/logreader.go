@@ -0,0 +1,214 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// followPollInterval follow 模式下检查新日志的间隔.
+const followPollInterval = 200 * time.Millisecond
+
+// LogReadOptions 读取日志的选项.
+type LogReadOptions struct {
+	Follow     bool      // 持续输出新的日志
+	Tail       int       // 只输出最后 N 行 小于 0 表示全部输出
+	Since      time.Time // 只输出这个时间之后的日志
+	Timestamps bool      // 在每行前面加上时间
+}
+
+// logFollower 跟踪一个日志文件 每次读取新写入的完整行.
+type logFollower struct {
+	f       *os.File
+	r       *bufio.Reader
+	pending []byte
+}
+
+func openFollower(path string) (*logFollower, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logFollower{f: f, r: bufio.NewReader(f)}, nil
+}
+
+// next 读取新写入的日志 没有完整的新行时返回空.
+func (lf *logFollower) next() ([]*LogEntry, error) {
+	var entries []*LogEntry
+	for {
+		line, err := lf.r.ReadBytes('\n')
+		lf.pending = append(lf.pending, line...)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entry := &LogEntry{}
+		if json.Unmarshal(bytes.TrimSpace(lf.pending), entry) == nil {
+			entries = append(entries, entry)
+		}
+		lf.pending = lf.pending[:0]
+	}
+}
+
+// rotated 判断日志文件是否已经被轮转 (路径指向了新的文件).
+func (lf *logFollower) rotated(path string) bool {
+	cur, err := lf.f.Stat()
+	if err != nil {
+		return false
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(cur, stat)
+}
+
+// sameFile 判断路径是否就是正在读取的文件.
+func (lf *logFollower) sameFile(path string) bool {
+	cur, err := lf.f.Stat()
+	if err != nil {
+		return false
+	}
+	stat, err := os.Stat(path)
+	return err == nil && os.SameFile(cur, stat)
+}
+
+func (lf *logFollower) close() {
+	lf.f.Close()
+}
+
+// readLogFile 读取一个完整的日志文件.
+func readLogFile(path string) ([]*LogEntry, error) {
+	lf, err := openFollower(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lf.close()
+	return lf.next()
+}
+
+// ReadLogs 读取容器的日志并按流输出到 stdout 和 stderr
+// follow 模式下会一直等待新的日志 直到 running 返回 false.
+func ReadLogs(name string, opts LogReadOptions, stdout, stderr io.Writer, running func() bool) error {
+	path := LogPath(name)
+	// 先打开当前的日志文件 再读轮转文件 这样中间发生轮转也不会漏掉当前文件
+	// 轮转后当前文件变成了 .1 读轮转文件时跳过它 由 current 读取
+	current, err := openFollower(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("container %s has no logs", name)
+		}
+		return err
+	}
+	defer func() { current.close() }()
+	// 从最旧的轮转文件开始读
+	var entries []*LogEntry
+	n := 1
+	for ; ; n++ {
+		if _, err := os.Stat(rotatedPath(path, n)); err != nil {
+			break
+		}
+	}
+	for n--; n >= 1; n-- {
+		if current.sameFile(rotatedPath(path, n)) {
+			continue
+		}
+		old, err := readLogFile(rotatedPath(path, n))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, old...)
+	}
+	newEntries, err := current.next()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, newEntries...)
+
+	entries = filterSince(entries, opts.Since)
+	if opts.Tail >= 0 && len(entries) > opts.Tail {
+		entries = entries[len(entries)-opts.Tail:]
+	}
+	for _, entry := range entries {
+		if err := writeEntry(entry, opts, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	for {
+		// 先判断是否还在运行 再读取 保证容器退出前最后的输出也能读到
+		alive := running()
+		newEntries, err := current.next()
+		if err != nil {
+			return err
+		}
+		if current.rotated(path) {
+			// 上面读完之后到轮转之前可能还写入了日志 再读一次旧文件 之后旧文件不会再有新的写入
+			last, err := current.next()
+			if err != nil {
+				return err
+			}
+			newEntries = append(newEntries, last...)
+			// 切换到轮转后新建的文件 旧文件末尾不完整的行保留下来
+			next, err := openFollower(path)
+			if err == nil {
+				next.pending = append(next.pending, current.pending...)
+				current.close()
+				current = next
+				more, err := current.next()
+				if err != nil {
+					return err
+				}
+				newEntries = append(newEntries, more...)
+			}
+		}
+		for _, entry := range filterSince(newEntries, opts.Since) {
+			if err := writeEntry(entry, opts, stdout, stderr); err != nil {
+				return err
+			}
+		}
+		if !alive {
+			return nil
+		}
+		time.Sleep(followPollInterval)
+	}
+}
+
+// filterSince 过滤掉指定时间之前的日志.
+func filterSince(entries []*LogEntry, since time.Time) []*LogEntry {
+	if since.IsZero() {
+		return entries
+	}
+	var filtered []*LogEntry
+	for _, entry := range entries {
+		if !entry.Time.Before(since) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// writeEntry 输出一条日志.
+func writeEntry(entry *LogEntry, opts LogReadOptions, stdout, stderr io.Writer) error {
+	w := stdout
+	if entry.Stream == "stderr" {
+		w = stderr
+	}
+	if opts.Timestamps {
+		if _, err := fmt.Fprintf(w, "%s ", entry.Time.Format(time.RFC3339Nano)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, entry.Log)
+	return err
+}
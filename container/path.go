@@ -0,0 +1,76 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 和 docker 一样 容器内的命令不存在时 run 的退出码为 127 存在但不能执行时为 126.
+const (
+	ExitCannotExecute = 126
+	ExitNotFound      = 127
+)
+
+// errNoSuchFile 命令的路径不存在.
+var errNoSuchFile = errors.New("no such file or directory")
+
+// ExecError 容器内的命令找不到或者不能执行 Code 为 run 的退出码
+// init 通过同步管道把它连同退出码传给 run.
+type ExecError struct {
+	Code int
+	Msg  string
+}
+
+func (e *ExecError) Error() string {
+	return e.Msg
+}
+
+// LookPath 在容器内按 PATH 查找命令 在 pivot_root 之后由 init 调用
+// 和 exec.LookPath 一样 带有 / 的命令不查找 PATH 相对路径相对于当前的工作目录
+// PATH 使用容器进程的 PATH 而不是 init 继承下来的宿主机的 PATH
+// 找不到或者不能执行时返回 *ExecError.
+func LookPath(file, path string) (string, error) {
+	if strings.Contains(file, "/") {
+		if err := findExecutable(file); err != nil {
+			code := ExitCannotExecute
+			if err == errNoSuchFile {
+				code = ExitNotFound
+			}
+			return "", &ExecError{Code: code, Msg: fmt.Sprintf("exec: %q: %s in container", file, err)}
+		}
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, file)
+		if findExecutable(candidate) == nil {
+			return candidate, nil
+		}
+	}
+	return "", &ExecError{Code: ExitNotFound,
+		Msg: fmt.Sprintf("exec: %q: executable file not found in container $PATH %s", file, path)}
+}
+
+// findExecutable 判断是否是当前用户可以执行的普通文件.
+func findExecutable(file string) error {
+	stat, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errNoSuchFile
+		}
+		return err
+	}
+	if stat.IsDir() {
+		return fmt.Errorf("is a directory")
+	}
+	if err := unix.Access(file, unix.X_OK); err != nil {
+		return fmt.Errorf("permission denied")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package container
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookPath(t *testing.T) {
+	dir := t.TempDir()
+	bin, sbin := filepath.Join(dir, "bin"), filepath.Join(dir, "sbin")
+	os.Mkdir(bin, 0755)
+	os.Mkdir(sbin, 0755)
+	os.WriteFile(filepath.Join(bin, "sh"), nil, 0755)
+	os.WriteFile(filepath.Join(bin, "data"), nil, 0644)
+	os.WriteFile(filepath.Join(sbin, "data"), nil, 0755)
+	path := sbin + ":" + bin
+
+	tests := []struct {
+		file, want string
+	}{
+		{"sh", filepath.Join(bin, "sh")},
+		{"data", filepath.Join(sbin, "data")},
+		{filepath.Join(bin, "sh"), filepath.Join(bin, "sh")},
+	}
+	for _, tt := range tests {
+		got, err := LookPath(tt.file, path)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.file, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.file, got, tt.want)
+		}
+	}
+
+	errs := []struct {
+		file, want string
+		code       int
+	}{
+		{"missing", "executable file not found in container", ExitNotFound},
+		{filepath.Join(bin, "missing"), "no such file or directory in container", ExitNotFound},
+		{filepath.Join(bin, "data"), "permission denied in container", ExitCannotExecute},
+		{bin, "is a directory in container", ExitCannotExecute},
+	}
+	for _, tt := range errs {
+		_, err := LookPath(tt.file, path)
+		var execErr *ExecError
+		if !errors.As(err, &execErr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.file, err, tt.want)
+			continue
+		}
+		if execErr.Code != tt.code {
+			t.Errorf("%s: got exit code %d, want %d", tt.file, execErr.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package container
+
+import (
+	"crypto/rand"
+	"duoker/cgroups"
+	"duoker/config"
+	"duoker/network"
+	"duoker/workspace"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Status 容器的运行状态.
+type Status string
+
+const (
+	StatusCreated Status = "created" // 已创建 init 进程还没有 exec 用户命令
+	StatusRunning Status = "running" // 运行中
+	StatusExited  Status = "exited"  // 已退出
+)
+
+const (
+	stateFile = "config.json" // 容器状态目录下的状态文件
+	lockFile  = "lock"        // 容器状态目录下的锁文件
+)
+
+// validName 容器名 直接作为状态目录名 不能包含 / 并且不能以 . 开头 否则 .. 等名字会指向状态目录之外.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// IsName 判断是否是合法的容器名.
+func IsName(s string) bool {
+	return validName.MatchString(s)
+}
+
+// Info 持久化的容器状态
+// 保存在 /workplace/duoker/containers/<name>/config.json.
+type Info struct {
+	ID       string            `json:"id"`                 // 容器 ID
+	Name     string            `json:"name"`               // 容器名 全局唯一
+	Pid      int               `json:"pid"`                // 容器 init 进程在宿主机上的 pid
+	ShimPid  int               `json:"shimPid"`            // 负责等待容器退出并清理的进程 前台运行时和启动完成之前是 run 进程
+	IP       string            `json:"ip"`                 // 容器的 IP 地址
+	Network  string            `json:"network"`            // 容器所在的网络
+	Device   string            `json:"device"`             // 宿主机一侧的 veth 设备名
+	Image    string            `json:"image"`              // run 时指定的镜像
+	ImageID  string            `json:"imageId"`            // 镜像仓库中的镜像 ID 直接使用目录作为镜像时为空
+	Lowerdir []string          `json:"lowerdir"`           // overlay 的只读层 已经解析为绝对路径
+	Command  []string          `json:"command"`            // 容器内执行的命令 包括镜像的 Entrypoint
+	Args     []string          `json:"args,omitempty"`     // 命令中 Entrypoint 之后的部分 即 run 指定的命令或者镜像的 Cmd
+	Mounts   []workspace.Mount `json:"mounts,omitempty"`   // run -v 挂载的卷和 --tmpfs 挂载的 tmpfs
+	ReadOnly bool              `json:"readOnly,omitempty"` // 根目录只读 (run --read-only)
+	Created  time.Time         `json:"created"`            // 创建时间
+	Status   Status            `json:"status"`             // 运行状态
+	ExitCode int               `json:"exitCode"`           // 退出码
+	Finished time.Time         `json:"finished"`           // 退出时间
+
+	LogMaxSize  int64 `json:"logMaxSize"`  // 单个日志文件的最大字节数
+	LogMaxFiles int   `json:"logMaxFiles"` // 最多保留的日志文件数
+	AutoRemove  bool  `json:"autoRemove"`  // 退出后自动删除 (run --rm)
+	Tty         bool  `json:"tty"`         // 在容器内分配伪终端 前台运行并且在终端中运行时为 true
+
+	MaskedPaths   []string `json:"maskedPaths,omitempty"`   // 容器内屏蔽的路径
+	ReadonlyPaths []string `json:"readonlyPaths,omitempty"` // 容器内只读的路径
+
+	Hostname   string             `json:"hostname"`             // 容器的主机名 默认为容器名 容器名不能作为主机名时为短 ID
+	Domainname string             `json:"domainname,omitempty"` // 容器的 NIS 域名 run --domainname
+	DNS        *network.DNSConfig `json:"dns,omitempty"`        // run --dns 等参数指定的 DNS 配置
+
+	Env        []string `json:"env"`            // 容器进程的环境变量 没有指定 HOME 时由 init 按用户的家目录设置
+	WorkingDir string   `json:"workingDir"`     // 容器进程的工作目录 exec 默认也使用这个目录
+	User       string   `json:"user,omitempty"` // 容器进程的用户 user[:group] 为空时是 root
+
+	Resources *cgroups.Resources `json:"resources,omitempty"` // 资源限制
+}
+
+// NewInfo 为新容器生成状态记录 此时还没有写入文件.
+func NewInfo(name string, command []string) (*Info, error) {
+	if !IsName(name) {
+		return nil, fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	return &Info{
+		ID:      id,
+		Name:    name,
+		Command: command,
+		Created: time.Now(),
+		Status:  StatusCreated,
+	}, nil
+}
+
+// randomID 生成 64 位十六进制的容器 ID.
+func randomID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate container id fail err=%s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ShortID 返回用于展示的短 ID.
+func (i *Info) ShortID() string {
+	if len(i.ID) > 12 {
+		return i.ID[:12]
+	}
+	return i.ID
+}
+
+// Dir 容器的状态目录.
+func Dir(name string) string {
+	return filepath.Join(config.ContainerStoragePath, name)
+}
+
+// Save 将容器状态写入文件
+// 先写临时文件再 rename 保证其他进程不会读到写了一半的文件.
+func (i *Info) Save() error {
+	dir := Dir(i.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("mkdir container dir fail err=%s", err)
+	}
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write container state fail err=%s", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, stateFile))
+}
+
+// Remove 删除容器的状态目录.
+func (i *Info) Remove() error {
+	if err := os.RemoveAll(Dir(i.Name)); err != nil {
+		return fmt.Errorf("remove container dir fail err=%s", err)
+	}
+	return nil
+}
+
+// Lock 对容器加文件锁 返回解锁函数
+// 用于保证 supervisor 和 stop/kill 等多个进程不会同时清理同一个容器.
+func Lock(name string) (func(), error) {
+	if err := os.MkdirAll(Dir(name), 0700); err != nil {
+		return nil, fmt.Errorf("mkdir container dir fail err=%s", err)
+	}
+	f, err := os.OpenFile(filepath.Join(Dir(name), lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open container lock fail err=%s", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock container fail err=%s", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// Alive 判断进程是否还在运行
+// 父进程退出后没有被及时回收的僵尸进程也视为已经退出.
+func Alive(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// /proc/<pid>/stat 的格式为 pid (comm) state ...
+	// comm 中可能包含空格和括号 所以从最后一个右括号之后解析
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// loadByName 根据容器名读取状态文件.
+func loadByName(name string) (*Info, error) {
+	if !IsName(name) {
+		return nil, fmt.Errorf("invalid container name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(Dir(name), stateFile))
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("parse container state fail name=%s err=%s", name, err)
+	}
+	info.refresh()
+	return info, nil
+}
+
+// Load 根据容器名或者 ID 前缀查找容器.
+func Load(nameOrID string) (*Info, error) {
+	if info, err := loadByName(nameOrID); err == nil {
+		return info, nil
+	}
+	infos, err := List()
+	if err != nil {
+		return nil, err
+	}
+	var found *Info
+	for _, info := range infos {
+		if !strings.HasPrefix(info.ID, nameOrID) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("multiple containers match id prefix %s", nameOrID)
+		}
+		found = info
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no such container: %s", nameOrID)
+	}
+	return found, nil
+}
+
+// List 列出所有容器 按创建时间排序.
+func List() ([]*Info, error) {
+	entries, err := os.ReadDir(config.ContainerStoragePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var infos []*Info
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := loadByName(entry.Name())
+		if err != nil {
+			// 状态文件还没写入或者已经损坏的目录直接跳过
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(a, b int) bool {
+		return infos[a].Created.Before(infos[b].Created)
+	})
+	return infos, nil
+}
+
+// refresh 记录为运行中但进程已经不存在时
+// (例如 run 进程被强制杀掉) 修正为已退出
+// 此时 Finished 仍为空 表示还没有进行清理.
+func (i *Info) refresh() {
+	if i.Status != StatusRunning || i.Pid <= 0 {
+		return
+	}
+	if !Alive(i.Pid) {
+		i.Status = StatusExited
+		i.ExitCode = -1
+	}
+}
+
+// Cleaned 容器退出后是否已经完成清理.
+func (i *Info) Cleaned() bool {
+	return !i.Finished.IsZero()
+}
@@ -0,0 +1,19 @@
+package container
+
+import "testing"
+
+func TestIsName(t *testing.T) {
+	for _, name := range []string{"c1", "web_1", "a.b-c", "0"} {
+		if !IsName(name) {
+			t.Errorf("%q should be valid", name)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "a/../../x", "/abs", ".hidden", "-x", "a b"} {
+		if IsName(name) {
+			t.Errorf("%q should be invalid", name)
+		}
+		if _, err := NewInfo(name, []string{"sh"}); err == nil {
+			t.Errorf("NewInfo(%q) should fail", name)
+		}
+	}
+}
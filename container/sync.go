@@ -0,0 +1,195 @@
+// Package container 管理容器的生命周期
+// sync 用于 run 父进程与 init 子进程之间的同步 取代之前 sleep + SIGUSR2 信号的方式
+// state 用于持久化容器的状态.
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// SyncType 父子进程之间传递的消息类型.
+type SyncType string
+
+const (
+	SyncNsReady       SyncType = "nsReady"       // 子进程 -> 父进程: 命名空间已经创建好
+	SyncNetConfigured SyncType = "netConfigured" // 父进程 -> 子进程: 容器网络已经配置完毕
+	SyncConsole       SyncType = "console"       // 子进程 -> 父进程: 附带容器内分配的伪终端 master
+	SyncRootfsReady   SyncType = "rootfsReady"   // 子进程 -> 父进程: 根文件系统已经准备好 即将 exec
+	SyncStarted       SyncType = "started"       // shim -> run -d: 容器已经成功启动
+	SyncError         SyncType = "error"         // 任意一方出错 附带错误信息
+)
+
+// childSyncFd 子进程中同步管道的文件描述符
+// 通过 cmd.ExtraFiles[0] 传入 所以固定为 3.
+const childSyncFd = 3
+
+// syncMsg 在同步管道中传输的消息 每条消息是一行 JSON.
+type syncMsg struct {
+	Type  SyncType `json:"type"`
+	Error string   `json:"error,omitempty"`
+	Code  int      `json:"code,omitempty"` // 错误是 *ExecError 时 run 的退出码
+}
+
+// err 还原对方发送的错误 带有退出码时还原为 *ExecError.
+func (m *syncMsg) err() error {
+	if m.Code != 0 {
+		return &ExecError{Code: m.Code, Msg: m.Error}
+	}
+	return errors.New(m.Error)
+}
+
+// SyncPipe 父子进程之间的同步管道.
+type SyncPipe struct {
+	f   *os.File
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+func newSyncPipe(f *os.File) *SyncPipe {
+	return &SyncPipe{f: f, enc: json.NewEncoder(f), dec: json.NewDecoder(f)}
+}
+
+// NewSyncPipe 创建一对 unix socket
+// parent 留在父进程中使用 child 通过 cmd.ExtraFiles 交给子进程.
+func NewSyncPipe() (*SyncPipe, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create sync socketpair fail err=%s", err)
+	}
+	parent := os.NewFile(uintptr(fds[0]), "sync-parent")
+	child := os.NewFile(uintptr(fds[1]), "sync-child")
+	return newSyncPipe(parent), child, nil
+}
+
+// ChildSyncPipe 在 init 子进程中拿到父进程传下来的同步管道
+// 设置 CLOEXEC 后 exec 成功时管道会被自动关闭 父进程读到 EOF 即可知道容器已经启动.
+func ChildSyncPipe() (*SyncPipe, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(childSyncFd, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFSOCK {
+		return nil, fmt.Errorf("sync pipe not found, init must be started by run")
+	}
+	syscall.CloseOnExec(childSyncFd)
+	return newSyncPipe(os.NewFile(childSyncFd, "sync-child")), nil
+}
+
+// Send 发送一条消息.
+func (p *SyncPipe) Send(t SyncType) error {
+	return p.enc.Encode(syncMsg{Type: t})
+}
+
+// SendError 将错误发送给另一端 *ExecError 的退出码一起发送.
+func (p *SyncPipe) SendError(err error) error {
+	msg := syncMsg{Type: SyncError, Error: err.Error()}
+	var execErr *ExecError
+	if errors.As(err, &execErr) {
+		msg.Code = execErr.Code
+	}
+	return p.enc.Encode(msg)
+}
+
+// SendFile 发送一条消息 同时通过 SCM_RIGHTS 把文件描述符传给另一端.
+func (p *SyncPipe) SendFile(t SyncType, f *os.File) error {
+	data, err := json.Marshal(syncMsg{Type: t})
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(f.Fd()))
+	if err := syscall.Sendmsg(int(p.f.Fd()), append(data, '\n'), rights, nil, 0); err != nil {
+		return fmt.Errorf("send %s fail err=%s", t, err)
+	}
+	return nil
+}
+
+// RecvFile 等待 SendFile 发送的消息 返回收到的文件
+// 需要直接读 socket 才能拿到文件描述符 所以不经过 JSON 解码器
+// 消息是一问一答的 这时解码器中不会有下一条消息的数据.
+func (p *SyncPipe) RecvFile(expected SyncType) (*os.File, error) {
+	// 解码器中只会剩下上一条消息末尾的换行
+	if buffered, _ := io.ReadAll(p.dec.Buffered()); len(bytes.TrimSpace(buffered)) > 0 {
+		return nil, fmt.Errorf("wait %s fail: unexpected buffered message", expected)
+	}
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := syscall.Recvmsg(int(p.f.Fd()), buf, oob, syscall.MSG_CMSG_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("wait %s fail err=%s", expected, err)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("wait %s fail: peer exited unexpectedly", expected)
+	}
+	var fds []int
+	if cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+		for _, cmsg := range cmsgs {
+			if rights, err := syscall.ParseUnixRights(&cmsg); err == nil {
+				fds = append(fds, rights...)
+			}
+		}
+	}
+	var msg syncMsg
+	err = json.Unmarshal(buf[:n], &msg)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("wait %s fail err=%s", expected, err)
+	case msg.Type == SyncError:
+		err = msg.err()
+	case msg.Type != expected:
+		err = fmt.Errorf("wait %s fail: unexpected message %s", expected, msg.Type)
+	case len(fds) != 1:
+		err = fmt.Errorf("wait %s fail: expect 1 file descriptor, got %d", expected, len(fds))
+	}
+	if err != nil {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(fds[0]), string(expected)), nil
+}
+
+// Wait 阻塞等待指定类型的消息
+// 收到对方的错误消息 或者对方提前退出 都会返回错误.
+func (p *SyncPipe) Wait(expected SyncType) error {
+	var msg syncMsg
+	if err := p.dec.Decode(&msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("wait %s fail: peer exited unexpectedly", expected)
+		}
+		return fmt.Errorf("wait %s fail err=%s", expected, err)
+	}
+	switch msg.Type {
+	case expected:
+		return nil
+	case SyncError:
+		return msg.err()
+	default:
+		return fmt.Errorf("wait %s fail: unexpected message %s", expected, msg.Type)
+	}
+}
+
+// WaitExec 在收到 rootfsReady 之后等待子进程 exec
+// 子进程 exec 成功时管道因为 CLOEXEC 被关闭 这里读到 EOF
+// exec 失败时子进程会发送错误消息.
+func (p *SyncPipe) WaitExec() error {
+	var msg syncMsg
+	if err := p.dec.Decode(&msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("wait exec fail err=%s", err)
+	}
+	if msg.Type == SyncError {
+		return msg.err()
+	}
+	return fmt.Errorf("wait exec fail: unexpected message %s", msg.Type)
+}
+
+// Close 关闭管道.
+func (p *SyncPipe) Close() error {
+	return p.f.Close()
+}
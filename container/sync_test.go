@@ -0,0 +1,74 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSyncPipeSendFile(t *testing.T) {
+	parent, childFile, err := NewSyncPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	child := newSyncPipe(childFile)
+	defer child.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// 和 init 一样 先收发普通消息 解码器中会留下换行
+	if err := child.Send(SyncNsReady); err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.Wait(SyncNsReady); err != nil {
+		t.Fatal(err)
+	}
+	if err := child.SendFile(SyncConsole, w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	got, err := parent.RecvFile(SyncConsole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := got.Write([]byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	got.Close()
+	buf := make([]byte, 2)
+	if _, err := r.Read(buf); err != nil || string(buf) != "ok" {
+		t.Fatalf("read %q err=%v", buf, err)
+	}
+
+	// 对方报告错误
+	child.SendError(errors.New("no pty"))
+	if _, err := parent.RecvFile(SyncConsole); err == nil || err.Error() != "no pty" {
+		t.Fatalf("expect error from peer, got %v", err)
+	}
+}
+
+func TestSyncPipeExecError(t *testing.T) {
+	parent, childFile, err := NewSyncPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	child := newSyncPipe(childFile)
+	defer child.Close()
+
+	// 退出码随错误一起传给父进程
+	child.SendError(fmt.Errorf("start fail: %w", &ExecError{Code: ExitNotFound, Msg: "not found"}))
+	var execErr *ExecError
+	if err := parent.WaitExec(); !errors.As(err, &execErr) || execErr.Code != ExitNotFound {
+		t.Fatalf("got error %v, want exit code %d", err, ExitNotFound)
+	}
+	child.SendError(errors.New("mount fail"))
+	if err := parent.Wait(SyncRootfsReady); err == nil || errors.As(err, &execErr) {
+		t.Fatalf("got error %#v, want plain error", err)
+	}
+}
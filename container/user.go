@@ -0,0 +1,176 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PasswdPath 容器内的用户数据库 在 pivot_root 之后读取.
+	PasswdPath = "/etc/passwd"
+	// GroupPath 容器内的组数据库.
+	GroupPath = "/etc/group"
+)
+
+// ExecUser 解析后的容器进程用户.
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int  // 附加组 不包括 Gid
+	Home   string // 家目录 用于设置 HOME
+}
+
+// passwdEntry /etc/passwd 中的一行 name:password:uid:gid:gecos:home:shell.
+type passwdEntry struct {
+	name     string
+	uid, gid int
+	home     string
+}
+
+// groupEntry /etc/group 中的一行 name:password:gid:member1,member2.
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// LookupUser 按容器内的 passwd 和 group 文件解析 run -u 指定的 user[:group]
+// user 和 group 都可以是名字或者数字 ID 名字必须存在 数字 ID 可以不在文件中
+// 和 docker 一样 没有指定时为 root 附加组是 group 文件中列出了该用户的组.
+func LookupUser(spec, passwdPath, groupPath string) (*ExecUser, error) {
+	userSpec, groupSpec := spec, ""
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		userSpec, groupSpec = spec[:i], spec[i+1:]
+	}
+	if userSpec == "" {
+		userSpec = "0"
+	}
+	users, err := readPasswd(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &ExecUser{Home: "/"}
+	var name string
+	uid, numeric := parseID(userSpec)
+	found := false
+	for _, e := range users {
+		if (numeric && e.uid == uid) || (!numeric && e.name == userSpec) {
+			u.Uid, u.Gid, u.Home, name, found = e.uid, e.gid, e.home, e.name, true
+			break
+		}
+	}
+	if !found {
+		if !numeric {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userSpec)
+		}
+		u.Uid = uid
+	}
+
+	if groupSpec != "" {
+		gid, numeric := parseID(groupSpec)
+		found := numeric
+		for _, g := range groups {
+			if !numeric && g.name == groupSpec {
+				gid, found = g.gid, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupSpec)
+		}
+		u.Gid = gid
+	}
+
+	if name != "" {
+		for _, g := range groups {
+			if g.gid != u.Gid && contains(g.members, name) {
+				u.Groups = append(u.Groups, g.gid)
+			}
+		}
+	}
+	return u, nil
+}
+
+// parseID 判断是否是数字 ID.
+func parseID(s string) (int, bool) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// readPasswd 读取 passwd 文件 文件不存在时返回空.
+func readPasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+		uid, ok1 := parseID(fields[2])
+		gid, ok2 := parseID(fields[3])
+		if ok1 && ok2 {
+			entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+		}
+	})
+	return entries, err
+}
+
+// readGroup 读取 group 文件 文件不存在时返回空.
+func readGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		gid, ok := parseID(fields[2])
+		if !ok {
+			return
+		}
+		var members []string
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, groupEntry{name: fields[0], gid: gid, members: members})
+	})
+	return entries, err
+}
+
+// readColonFile 逐行读取以冒号分隔的文件 跳过空行和注释.
+func readColonFile(path string, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("open %s fail err=%s", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	return scanner.Err()
+}
+
+// contains 判断列表中是否有 s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
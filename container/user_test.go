@@ -0,0 +1,43 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookupUser(t *testing.T) {
+	dir := t.TempDir()
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	os.WriteFile(passwd, []byte("root:x:0:0:root:/root:/bin/sh\n# comment\napp:x:1000:1000::/home/app:/bin/sh\n"), 0644)
+	os.WriteFile(group, []byte("root:x:0:\napp:x:1000:\nwheel:x:10:app,other\naudio:x:29:app\n"), 0644)
+
+	tests := []struct {
+		spec string
+		want ExecUser
+	}{
+		{"", ExecUser{Uid: 0, Gid: 0, Home: "/root"}},
+		{"app", ExecUser{Uid: 1000, Gid: 1000, Groups: []int{10, 29}, Home: "/home/app"}},
+		{"1000", ExecUser{Uid: 1000, Gid: 1000, Groups: []int{10, 29}, Home: "/home/app"}},
+		{"app:wheel", ExecUser{Uid: 1000, Gid: 10, Groups: []int{29}, Home: "/home/app"}},
+		{"app:5", ExecUser{Uid: 1000, Gid: 5, Groups: []int{10, 29}, Home: "/home/app"}},
+		{"2000", ExecUser{Uid: 2000, Gid: 0, Home: "/"}},
+		{"2000:2000", ExecUser{Uid: 2000, Gid: 2000, Home: "/"}},
+	}
+	for _, tt := range tests {
+		u, err := LookupUser(tt.spec, passwd, group)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.spec, err)
+		}
+		if !reflect.DeepEqual(*u, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.spec, *u, tt.want)
+		}
+	}
+	for _, spec := range []string{"nobody", "app:nogroup"} {
+		if _, err := LookupUser(spec, passwd, group); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"duoker/cgroups"
+	"duoker/cli"
+	"duoker/container"
+	"duoker/log"
+	"duoker/nsenter"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// defaultWorkDir 容器内默认的工作目录 run 没有指定 -w 并且镜像中也没有时使用.
+const defaultWorkDir = "/"
+
+// execFlags exec 命令的参数.
+type execFlags struct {
+	interactive bool
+	tty         bool
+	workDir     string
+	user        string
+}
+
+// execContainer 在运行中的容器内执行命令
+// ./duoker exec [-i] [-t] [-w dir] [-u user[:group]] NAME COMMAND [ARG...]
+// 第一次调用时 (宿主机上) 读取容器的 pid 和环境变量 带上环境变量重新执行自己
+// 第二次调用时 nsenter 已经在 Go 运行时启动前加入了容器的命名空间 直接执行命令即可
+// 返回命令的退出码.
+func execContainer(args []string) (int, error) {
+	fs := cli.NewFlagSet("exec", "exec [OPTIONS] NAME COMMAND [ARG...]")
+	opts := &execFlags{}
+	fs.BoolVar(&opts.interactive, "i", false, "keep STDIN open")
+	fs.BoolVar(&opts.tty, "t", false, "allocate a pseudo-TTY inside the container")
+	it := fs.Bool("it", false, "shorthand for -i -t")
+	fs.StringVar(&opts.workDir, "w", "", "working directory inside the container")
+	fs.StringVar(&opts.user, "u", "", "user[:group] to run the command as, defaults to the user of the container process")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return 1, fs.UsageError()
+	}
+	if *it {
+		opts.interactive, opts.tty = true, true
+	}
+	if os.Getenv(nsenter.PidEnv) != "" {
+		return execInNamespace(opts, fs.Args()[1:])
+	}
+	return execEnter(opts, fs.Arg(0), fs.Args()[1:])
+}
+
+// execEnter 在宿主机上准备好容器的环境变量后 重新执行自己进入容器.
+func execEnter(opts *execFlags, name string, command []string) (int, error) {
+	info, err := loadRunning(name)
+	if err != nil {
+		return 1, err
+	}
+	if opts.tty && !isTerminal(os.Stdin) {
+		return 1, fmt.Errorf("the input device is not a TTY")
+	}
+	env, err := containerEnv(info.Pid)
+	if err != nil {
+		return 1, err
+	}
+	if opts.tty && !hasEnv(env, "TERM") {
+		env = append(env, "TERM=xterm")
+	}
+	// 默认和容器进程使用同一个工作目录
+	if opts.workDir == "" {
+		opts.workDir = info.WorkingDir
+	}
+	if opts.workDir == "" {
+		opts.workDir = defaultWorkDir
+	}
+	// 默认和容器进程使用同一个用户 指定了其他用户时 HOME 在容器内按该用户的家目录重新设置
+	if opts.user == "" {
+		opts.user = info.User
+	} else if opts.user != info.User {
+		env = withoutEnv(env, "HOME")
+	}
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return 1, fmt.Errorf("get exec process error %s", err)
+	}
+
+	args := []string{"exec", "-w", opts.workDir, "-u", opts.user}
+	if opts.interactive {
+		args = append(args, "-i")
+	}
+	if opts.tty {
+		args = append(args, "-t")
+	}
+	args = append(args, info.Name)
+	cmd := exec.Command(self, append(args, command...)...)
+	cmd.Env = append(env, fmt.Sprintf("%s=%d", nsenter.PidEnv, info.Pid))
+	if opts.interactive {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := startInCgroup(cmd, info.Name); err != nil {
+		return 1, err
+	}
+	cmd.Wait()
+	return exitCode(cmd.ProcessState), nil
+}
+
+// startInCgroup 启动重新执行的自己 并在 nsenter fork 出容器内的进程之前把它加入容器的 cgroup
+// 这样 exec 的命令和它的子进程同样受容器资源限制的约束 容器没有 cgroup 时直接启动.
+func startInCgroup(cmd *exec.Cmd, name string) error {
+	cgroup, err := cgroups.New(name)
+	if err != nil {
+		log.Debug("skip cgroup %s", err)
+		return cmd.Start()
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("create exec sync pipe fail %s", err)
+	}
+	defer w.Close()
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=3", nsenter.SyncEnv))
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("exec fail %s", err)
+	}
+	if err := cgroup.Apply(cmd.Process.Pid); err != nil {
+		// 关闭管道后 nsenter 退出
+		w.Close()
+		cmd.Wait()
+		return fmt.Errorf("join container cgroup fail %s", err)
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		cmd.Wait()
+		return fmt.Errorf("sync exec process fail %s", err)
+	}
+	return nil
+}
+
+// execInNamespace 已经处于容器的命名空间中
+// 此时的文件系统是容器内的 和 init 一样按容器进程的 PATH 查找命令 按容器内的 passwd 和 group 解析用户
+// 命令找不到时退出码为 127 不能执行时为 126 和 run 一致.
+func execInNamespace(opts *execFlags, command []string) (int, error) {
+	env := withoutEnv(withoutEnv(os.Environ(), nsenter.PidEnv), nsenter.SyncEnv)
+	user, err := container.LookupUser(opts.user, container.PasswdPath, container.GroupPath)
+	if err != nil {
+		return 1, err
+	}
+	if !hasEnv(env, "HOME") {
+		env = append(env, "HOME="+user.Home)
+	}
+	// 相对路径的命令相对于工作目录查找
+	if err := os.Chdir(opts.workDir); err != nil {
+		return container.ExitCannotExecute, fmt.Errorf("chdir to %s in container fail %s", opts.workDir, err)
+	}
+	path, _ := container.LookupEnv(env, "PATH")
+	executable, err := container.LookPath(command[0], path)
+	if err != nil {
+		var execErr *container.ExecError
+		if errors.As(err, &execErr) {
+			return execErr.Code, err
+		}
+		return 1, err
+	}
+	// 已经找到了命令 不再让 exec.Command 按当前进程的 PATH 查找
+	cmd := &exec.Cmd{Path: executable, Args: command}
+	cmd.Env = env
+	cmd.Dir = opts.workDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential(user)}
+	if opts.tty {
+		// 在容器的 /dev/pts 中分配伪终端 容器没有 /dev/ptmx 时 (旧版本创建的容器) 直接使用当前终端
+		if console, err := container.NewConsole(); err == nil {
+			return execConsole(cmd, console, opts.interactive)
+		}
+	}
+	if opts.interactive {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return waitCommand(cmd)
+}
+
+// execConsole 以容器内的伪终端作为命令的标准输入输出和控制终端 在当前终端和伪终端之间转发.
+func execConsole(cmd *exec.Cmd, console *container.Console, interactive bool) (int, error) {
+	defer console.Close()
+	console.ResizeFrom(os.Stdout)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = console.Slave, console.Slave, console.Slave
+	cmd.SysProcAttr.Setsid, cmd.SysProcAttr.Setctty, cmd.SysProcAttr.Ctty = true, true, 0
+	if err := cmd.Start(); err != nil {
+		return startFailure(cmd, err)
+	}
+	// 关闭 slave 之后 命令和它的子进程都退出时读 master 才会结束
+	console.Slave.Close()
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, console.Master)
+		close(copied)
+	}()
+	if interactive {
+		if restore, err := container.SetRawTerminal(os.Stdin); err == nil {
+			defer restore()
+		}
+		go io.Copy(console.Master, os.Stdin)
+	}
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			console.ResizeFrom(os.Stdout)
+		}
+	}()
+	cmd.Wait()
+	<-copied
+	return exitCode(cmd.ProcessState), nil
+}
+
+// waitCommand 执行命令并返回它的退出码.
+func waitCommand(cmd *exec.Cmd) (int, error) {
+	if err := cmd.Start(); err != nil {
+		return startFailure(cmd, err)
+	}
+	cmd.Wait()
+	return exitCode(cmd.ProcessState), nil
+}
+
+// startFailure 命令已经找到但启动失败 例如脚本的解释器不存在 和 init 一样按错误区分退出码.
+func startFailure(cmd *exec.Cmd, err error) (int, error) {
+	code := container.ExitCannotExecute
+	if errors.Is(err, syscall.ENOENT) {
+		code = container.ExitNotFound
+	}
+	return code, fmt.Errorf("exec %s fail %s", cmd.Args[0], err)
+}
+
+// containerEnv 读取容器 1 号进程的环境变量.
+func containerEnv(pid int) ([]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, fmt.Errorf("read container environ fail %s", err)
+	}
+	var env []string
+	for _, kv := range bytes.Split(data, []byte{0}) {
+		if len(kv) > 0 {
+			env = append(env, string(kv))
+		}
+	}
+	return env, nil
+}
+
+// credential 把容器内解析出的用户转换为启动进程时使用的凭据.
+func credential(user *container.ExecUser) *syscall.Credential {
+	groups := make([]uint32, 0, len(user.Groups))
+	for _, gid := range user.Groups {
+		groups = append(groups, uint32(gid))
+	}
+	return &syscall.Credential{Uid: uint32(user.Uid), Gid: uint32(user.Gid), Groups: groups}
+}
+
+// withoutEnv 去掉环境变量中的 key.
+func withoutEnv(env []string, key string) []string {
+	var out []string
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// hasEnv 判断环境变量中是否已经设置了 key.
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// isTerminal 判断文件是否是终端.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"duoker/image"
+	"duoker/workspace"
+	"fmt"
+	"io"
+	"os"
+)
+
+// export 把容器的文件系统导出为 tar 包
+// ./duoker export [-o FILE] NAME
+// 没有指定 -o 时写到标准输出 只能导出已经退出的容器
+// 导出时把读写层作为另一个 overlay 的只读层 运行中的容器还在修改读写层 overlayfs 不支持这样使用.
+func export(args []string) error {
+	fs := cli.NewFlagSet("export", "export [-o FILE] NAME")
+	output := fs.String("o", "", "write to a file instead of STDOUT")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fs.UsageError()
+	}
+	info, err := container.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if info.Status != container.StatusExited && container.Alive(info.Pid) {
+		return fmt.Errorf("container %s is running, stop it before export", info.Name)
+	}
+	var out io.Writer = os.Stdout
+	if *output == "" {
+		if isTerminal(os.Stdout) {
+			return fmt.Errorf("refusing to write tar archive to a terminal, use -o or redirect the output")
+		}
+	} else {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("create %s fail %s", *output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	dir, unmount, err := workspace.MountSnapshot(info.Name, info.Lowerdir)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+	if err := image.WriteTar(out, dir, false); err != nil {
+		if *output != "" {
+			os.Remove(*output)
+		}
+		return fmt.Errorf("export container %s fail %s", info.Name, err)
+	}
+	return nil
+}
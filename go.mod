@@ -5,10 +5,8 @@ go 1.20
 require (
 	github.com/ThreeKing2018/gocolor v0.0.0-20190625094635-394e0e24c0d0
 	github.com/vishvananda/netlink v1.1.0
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
+	golang.org/x/sys v0.0.0-20190606203320-7fc4e5ec1444
 )
 
-require (
-	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df // indirect
-	golang.org/x/sys v0.0.0-20190606203320-7fc4e5ec1444 // indirect
-)
+require github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,247 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	whiteoutPrefix = ".wh."          // 删除文件的标记 .wh.<name> 表示下层的 <name> 已经被删除
+	whiteoutOpaque = ".wh..wh..opq"  // 不透明目录的标记 表示下层中这个目录的内容全部被隐藏
+	paxXattrPrefix = "SCHILY.xattr." // tar 中 PAX 扩展头保存 xattr 的前缀
+	opaqueXattr    = "trusted.overlay.opaque"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// cmdReader 读取外部解压命令的输出 Close 时等待命令退出.
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s fail err=%s", r.cmd.Args[0], err)
+	}
+	return nil
+}
+
+// decompress 根据文件头自动识别压缩格式
+// gzip 使用标准库 xz 和 zstd 标准库不支持 交给系统中的 xz 和 zstd 命令.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(6)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	var tool string
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, xzMagic):
+		tool = "xz"
+	case bytes.HasPrefix(head, zstdMagic):
+		tool = "zstd"
+	default:
+		return io.NopCloser(br), nil
+	}
+	cmd := exec.Command(tool, "-d", "-c")
+	cmd.Stdin = br
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s to decompress fail err=%s", tool, err)
+	}
+	return &cmdReader{ReadCloser: out, cmd: cmd}, nil
+}
+
+// applyLayer 把一层的 tar 包解压到 dir
+// 镜像层中的 whiteout 文件转换为 overlay 的格式
+// .wh.<name> 转换为同名的 0/0 字符设备 .wh..wh..opq 转换为目录的 trusted.overlay.opaque=y.
+func applyLayer(r io.Reader, dir string) error {
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirTimes []dirTime
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar fail err=%s", err)
+		}
+		// git archive 等工具会写入 pax 全局头 只包含元数据 不对应文件
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name := filepath.Clean("/" + hdr.Name)
+		if name == "/" && hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		parent := filepath.Dir(path)
+		if err := checkParent(dir, parent); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return fmt.Errorf("mkdir %s fail err=%s", parent, err)
+		}
+
+		base := filepath.Base(name)
+		if base == whiteoutOpaque {
+			if err := unix.Lsetxattr(parent, opaqueXattr, []byte("y"), 0); err != nil {
+				return fmt.Errorf("set opaque dir %s fail err=%s", parent, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			removed := strings.TrimPrefix(base, whiteoutPrefix)
+			if removed == "" {
+				return fmt.Errorf("invalid whiteout entry %s in layer", hdr.Name)
+			}
+			target := filepath.Join(parent, removed)
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("remove %s fail err=%s", target, err)
+			}
+			if err := unix.Mknod(target, unix.S_IFCHR, 0); err != nil {
+				return fmt.Errorf("create whiteout %s fail err=%s", target, err)
+			}
+			continue
+		}
+
+		// 同一个 tar 包中后出现的条目覆盖前面的 目录除外
+		if stat, err := os.Lstat(path); err == nil && !(stat.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("remove %s fail err=%s", path, err)
+			}
+		}
+		if err := createEntry(tr, hdr, dir, path); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return fmt.Errorf("chown %s fail err=%s", path, err)
+		}
+		for key, value := range hdr.PAXRecords {
+			if !strings.HasPrefix(key, paxXattrPrefix) {
+				continue
+			}
+			if err := unix.Lsetxattr(path, strings.TrimPrefix(key, paxXattrPrefix), []byte(value), 0); err != nil {
+				return fmt.Errorf("set xattr %s on %s fail err=%s", key, path, err)
+			}
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			// chown 会清掉 setuid 位 所以最后再设置权限
+			if err := os.Chmod(path, hdr.FileInfo().Mode()); err != nil {
+				return fmt.Errorf("chmod %s fail err=%s", path, err)
+			}
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			// 目录中创建文件会修改目录的时间 全部解压完后再设置
+			dirTimes = append(dirTimes, dirTime{path, hdr.ModTime})
+		} else if err := setTime(path, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := setTime(dirTimes[i].path, dirTimes[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createEntry 根据 tar 条目的类型创建文件.
+func createEntry(tr *tar.Reader, hdr *tar.Header, dir, path string) error {
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("mkdir %s fail err=%s", path, err)
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("create %s fail err=%s", path, err)
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("write %s fail err=%s", path, err)
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return fmt.Errorf("symlink %s fail err=%s", path, err)
+		}
+	case tar.TypeLink:
+		target := filepath.Join(dir, filepath.Clean("/"+hdr.Linkname))
+		if err := checkParent(dir, filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := os.Link(target, path); err != nil {
+			return fmt.Errorf("link %s fail err=%s", path, err)
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			mode = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			mode = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(path, mode|uint32(hdr.Mode&07777), int(dev)); err != nil {
+			return fmt.Errorf("mknod %s fail err=%s", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported tar entry %s type %c", hdr.Name, hdr.Typeflag)
+	}
+	return nil
+}
+
+// checkParent 确认解压的路径没有经过符号链接
+// 否则 tar 包可以通过 a -> / 和 a/etc/passwd 这样的条目写到 dir 之外.
+func checkParent(dir, parent string) error {
+	for p := parent; len(p) > len(dir); p = filepath.Dir(p) {
+		stat, err := os.Lstat(p)
+		if err != nil {
+			continue
+		}
+		if stat.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("tar entry under symlink %s is not allowed", strings.TrimPrefix(p, dir))
+		}
+	}
+	return nil
+}
+
+// setTime 设置文件的修改时间 不跟随符号链接.
+func setTime(path string, mtime time.Time) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("set time of %s fail err=%s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Commit 把容器的读写层作为新的一层 叠加在 parent 的各层之上生成新镜像
+// upperdir 中 overlay 格式的 whiteout 会转换为通用的 .wh. 文件 再按导入的方式解压为新的层
+// 运行中的容器不会被暂停 提交的是读取那一刻读写层的内容.
+func Commit(parent *Image, upperdir string, cfg Config, ref string) (*Image, error) {
+	name, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	tmp, err := makeTmp("commit-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// 先打包读写层 同时计算这一层的摘要
+	tarPath := filepath.Join(tmp, "layer.tar")
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return nil, fmt.Errorf("create layer tar fail err=%s", err)
+	}
+	h := sha256.New()
+	err = WriteTar(io.MultiWriter(f, h), upperdir, true)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("tar container layer fail %s", err)
+	}
+	diffID := hex.EncodeToString(h.Sum(nil))
+	if !layerExists(diffID) {
+		dir := filepath.Join(tmp, diffDir)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, fmt.Errorf("mkdir layer fail err=%s", err)
+		}
+		if _, err := unpackLayer(tarPath, dir); err != nil {
+			return nil, fmt.Errorf("unpack container layer fail %s", err)
+		}
+		if err := storeLayer(dir, diffID); err != nil {
+			return nil, err
+		}
+	}
+
+	img := &Image{
+		Created: time.Now(),
+		Layers:  append(append([]string{}, parent.Layers...), diffID),
+		Config:  cfg,
+	}
+	if img.ID, err = contentID(img.Layers, img.Config); err != nil {
+		return nil, err
+	}
+	return img, addImage(img, []string{name})
+}
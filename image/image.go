@@ -0,0 +1,267 @@
+// Package image 管理容器镜像
+// 镜像仓库位于 /workplace/duoker/images 结构如下
+//
+//	repositories.json        镜像名 name:tag 到镜像 ID 的映射
+//	<id>/image.json          镜像的记录 包括层和默认的运行配置
+//	layers/<diffID>/diff     解压后的一层 作为容器 overlay 的只读层 内容相同的层在镜像之间共用
+//	l/<linkID>               指向 layers/<diffID>/diff 的短符号链接
+//
+// import 和 load 负责把 tar 包解压到仓库中.
+package image
+
+import (
+	"duoker/config"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	repositoriesFile = "repositories.json" // 镜像名到镜像 ID 的映射
+	imageFile        = "image.json"        // 镜像目录下的镜像记录
+	tmpDir           = "tmp"               // 解压过程中使用的临时目录 完成后 rename 到镜像目录
+	lockFile         = "lock"              // 修改仓库时使用的锁
+	defaultTag       = "latest"
+)
+
+var (
+	// validName 镜像名 例如 ubuntu library/ubuntu localhost:5000/my-app.
+	validName = regexp.MustCompile(`^[a-z0-9]+([._-]+[a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`)
+	// validTag 镜像的 tag 例如 22.04 latest.
+	validTag = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+)
+
+// Config 镜像中记录的默认运行配置 对应 OCI 镜像配置中的 config 部分.
+type Config struct {
+	Env        []string `json:"Env,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+	User       string   `json:"User,omitempty"`
+}
+
+// Image 镜像仓库中的一个镜像.
+type Image struct {
+	ID      string    `json:"id"`      // 镜像 ID 64 位十六进制
+	Created time.Time `json:"created"` // 导入的时间
+	Layers  []string  `json:"layers"`  // 每一层解压后内容的 sha256 从最底层开始
+	Config  Config    `json:"config"`  // 默认的运行配置
+}
+
+// ParseReference 解析 name[:tag] 省略 tag 时为 latest 返回 name:tag.
+func ParseReference(ref string) (string, error) {
+	name, tag := ref, defaultTag
+	// 名字中可能带有 registry 的端口 只有最后一个 / 之后的 : 才是 tag 的分隔符
+	if i := strings.LastIndexByte(ref, ':'); i > strings.LastIndexByte(ref, '/') {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid image name %s", name)
+	}
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("invalid image tag %s", tag)
+	}
+	return name + ":" + tag, nil
+}
+
+// Dir 镜像在仓库中的目录.
+func Dir(id string) string {
+	return filepath.Join(config.ImageStorePath, id)
+}
+
+// Lowerdirs 镜像各层的目录 按 overlay lowerdir 的顺序 最上层在前.
+func (img *Image) Lowerdirs() ([]string, error) {
+	dirs := make([]string, 0, len(img.Layers))
+	for n := len(img.Layers) - 1; n >= 0; n-- {
+		dir, err := layerLink(img.Layers[n])
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// isPath 判断 run 命令中的 IMAGE 是否是一个目录路径而不是仓库中的镜像名
+// 镜像名中也可以有 / 所以相对路径需要以 ./ 开头.
+func isPath(ref string) bool {
+	return strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, ".")
+}
+
+// Resolve 把 IMAGE 解析为容器 overlay 的只读层
+// 以 / 或者 . 开头时作为根文件系统的目录 相对路径按当前目录解析 此时返回的镜像为 nil
+// 否则作为镜像名 name[:tag] 或镜像 ID 在镜像仓库中查找.
+func Resolve(ref string) ([]string, *Image, error) {
+	if !isPath(ref) {
+		img, err := Get(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		lowerdirs, err := img.Lowerdirs()
+		if err != nil {
+			return nil, nil, err
+		}
+		return lowerdirs, img, nil
+	}
+	rootfs, err := filepath.Abs(ref)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve image path %s fail err=%s", ref, err)
+	}
+	stat, err := os.Stat(rootfs)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("image %s not found: %s does not exist", ref, rootfs)
+		}
+		return nil, nil, fmt.Errorf("stat image %s fail err=%s", ref, err)
+	}
+	if !stat.IsDir() {
+		return nil, nil, fmt.Errorf("image %s: %s is not a directory", ref, rootfs)
+	}
+	return []string{rootfs}, nil, nil
+}
+
+// Get 根据镜像名或者镜像 ID 前缀查找镜像.
+func Get(ref string) (*Image, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	if name, err := ParseReference(ref); err == nil {
+		if id, ok := repos[name]; ok {
+			return load(id)
+		}
+	}
+	// 按 ID 前缀查找
+	if len(ref) >= 4 && len(ref) <= 64 {
+		ids, err := listIDs()
+		if err != nil {
+			return nil, err
+		}
+		var found string
+		for _, id := range ids {
+			if !strings.HasPrefix(id, ref) {
+				continue
+			}
+			if found != "" {
+				return nil, fmt.Errorf("multiple images match id prefix %s", ref)
+			}
+			found = id
+		}
+		if found != "" {
+			return load(found)
+		}
+	}
+	return nil, fmt.Errorf("image %s not found in image store %s", ref, config.ImageStorePath)
+}
+
+// load 读取镜像记录.
+func load(id string) (*Image, error) {
+	data, err := os.ReadFile(filepath.Join(Dir(id), imageFile))
+	if err != nil {
+		return nil, fmt.Errorf("read image %s fail err=%s", id, err)
+	}
+	img := &Image{}
+	if err := json.Unmarshal(data, img); err != nil {
+		return nil, fmt.Errorf("parse image %s fail err=%s", id, err)
+	}
+	return img, nil
+}
+
+// save 写入镜像记录.
+func (img *Image) save(dir string) error {
+	data, err := json.MarshalIndent(img, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, imageFile), data, 0644)
+}
+
+// listIDs 列出仓库中所有镜像的 ID.
+func listIDs() ([]string, error) {
+	entries, err := os.ReadDir(config.ImageStorePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// layers l tmp 等目录下没有 image.json
+		if _, err := os.Stat(filepath.Join(config.ImageStorePath, entry.Name(), imageFile)); err == nil {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// loadRepositories 读取镜像名到镜像 ID 的映射.
+func loadRepositories() (map[string]string, error) {
+	repos := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(config.ImageStorePath, repositoriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repos, nil
+		}
+		return nil, fmt.Errorf("read repositories fail err=%s", err)
+	}
+	if err := json.Unmarshal(data, &repos); err != nil {
+		return nil, fmt.Errorf("parse repositories fail err=%s", err)
+	}
+	return repos, nil
+}
+
+// saveRepositories 写入镜像名到镜像 ID 的映射.
+func saveRepositories(repos map[string]string) error {
+	data, err := json.MarshalIndent(repos, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(config.ImageStorePath, repositoriesFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write repositories fail err=%s", err)
+	}
+	return os.Rename(tmp, filepath.Join(config.ImageStorePath, repositoriesFile))
+}
+
+// lock 对镜像仓库加文件锁 返回解锁函数.
+func lock() (func(), error) {
+	if err := os.MkdirAll(config.ImageStorePath, 0700); err != nil {
+		return nil, fmt.Errorf("mkdir image store fail err=%s", err)
+	}
+	f, err := os.OpenFile(filepath.Join(config.ImageStorePath, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open image store lock fail err=%s", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock image store fail err=%s", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// tag 把镜像名指向镜像 调用方需要持有仓库锁.
+func tag(names []string, id string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		repos[name] = id
+	}
+	return saveRepositories(repos)
+}
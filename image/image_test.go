@@ -0,0 +1,185 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	cases := map[string]string{
+		"ubuntu":                 "ubuntu:latest",
+		"ubuntu:22.04":           "ubuntu:22.04",
+		"library/ubuntu":         "library/ubuntu:latest",
+		"localhost:5000/app:v1":  "localhost:5000/app:v1",
+		"my-app.test/web_ui:1.0": "my-app.test/web_ui:1.0",
+	}
+	for ref, want := range cases {
+		got, err := ParseReference(ref)
+		if err != nil {
+			t.Fatalf("parse %s: %s", ref, err)
+		}
+		if got != want {
+			t.Fatalf("parse %s = %s, want %s", ref, got, want)
+		}
+	}
+	for _, ref := range []string{"", "Ubuntu", "ubuntu:", "ubuntu:a:b", "../x"} {
+		if _, err := ParseReference(ref); err == nil {
+			t.Fatalf("expect %q to be invalid", ref)
+		}
+	}
+}
+
+// layerTar 生成一层的 tar 包 内容为 文件名 -> 文件内容 以 / 结尾的为目录.
+func layerTar(t *testing.T, entries ...string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for i := 0; i < len(entries); i += 2 {
+		name, content := entries[i], entries[i+1]
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestApplyLayerWhiteout(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating whiteout devices requires root")
+	}
+	dir := t.TempDir()
+	data := layerTar(t,
+		"data/", "",
+		"data/.wh..wh..opq", "",
+		"data/b", "b",
+		"etc/.wh.gone", "",
+	)
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(data)
+	zw.Close()
+	r, err := decompress(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyLayer(r, dir); err != nil {
+		t.Fatal(err)
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(dir, "etc/gone"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != 0 {
+		t.Fatalf("expect etc/gone to be a 0/0 character device")
+	}
+	value := make([]byte, 1)
+	if n, err := unix.Lgetxattr(filepath.Join(dir, "data"), opaqueXattr, value); err != nil || string(value[:n]) != "y" {
+		t.Fatalf("expect data to be opaque err=%v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data", whiteoutOpaque)); !os.IsNotExist(err) {
+		t.Fatalf("opaque marker should not be unpacked")
+	}
+}
+
+func TestApplyLayerRejectsSymlinkEscape(t *testing.T) {
+	dir := t.TempDir()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/tmp", Mode: 0777})
+	tw.WriteHeader(&tar.Header{Name: "escape/pwned", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	if err := applyLayer(buf, dir); err == nil {
+		t.Fatal("expect entry under symlink to be rejected")
+	}
+}
+
+func TestApplyLayerSkipsGlobalHeader(t *testing.T) {
+	dir := t.TempDir()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	// 和 git archive 一样 第一个条目是记录 commit 的 pax 全局头
+	tw.WriteHeader(&tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader,
+		PAXRecords: map[string]string{"comment": "0123456789abcdef"}})
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+	tw.Write([]byte("a"))
+	tw.Close()
+	if err := applyLayer(buf, dir); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a")); err != nil || string(data) != "a" {
+		t.Errorf("got %q %v", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "pax_global_header")); !os.IsNotExist(err) {
+		t.Errorf("global header should not be extracted, err=%v", err)
+	}
+}
+
+func TestApplyLayerEmptyWhiteout(t *testing.T) {
+	for _, name := range []string{".wh.", "sub/.wh."} {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "keep"), []byte("k"), 0644)
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg})
+		tw.Close()
+		if err := applyLayer(buf, dir); err == nil {
+			t.Errorf("%s: expected error for empty whiteout name", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "keep")); err != nil {
+			t.Errorf("%s: layer dir damaged err=%v", name, err)
+		}
+	}
+}
+
+func TestWriteTarWhiteout(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("creating whiteout devices requires root")
+	}
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "data"), 0755)
+	os.WriteFile(filepath.Join(dir, "data", "c"), []byte("c"), 0644)
+	os.Link(filepath.Join(dir, "data", "c"), filepath.Join(dir, "data", "d"))
+	if err := unix.Lsetxattr(filepath.Join(dir, "data"), opaqueXattr, []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mknod(filepath.Join(dir, "gone"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteTar(buf, dir, true); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*tar.Header{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		got[hdr.Name] = hdr
+	}
+	for _, name := range []string{"data/", "data/.wh..wh..opq", "data/c", ".wh.gone"} {
+		if _, ok := got[name]; !ok {
+			t.Fatalf("expect %s in tar, got %v", name, got)
+		}
+	}
+	if hdr := got["data/d"]; hdr == nil || hdr.Typeflag != tar.TypeLink || hdr.Linkname != "data/c" {
+		t.Fatalf("expect data/d to be a hardlink to data/c")
+	}
+	if _, ok := got["gone"]; ok {
+		t.Fatalf("whiteout device should not be written as is")
+	}
+	if _, ok := got["data/"].PAXRecords[paxXattrPrefix+opaqueXattr]; ok {
+		t.Fatalf("overlay xattr should not be written")
+	}
+}
@@ -0,0 +1,81 @@
+package image
+
+import (
+	"crypto/rand"
+	"duoker/config"
+	"encoding/base32"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	layersDir = "layers" // 按内容寻址保存各层 layers/<diffID>/diff
+	linkDir   = "l"      // 指向各层 diff 目录的短符号链接 l/<linkID>
+	diffDir   = "diff"   // 层目录下解压后的内容
+	linkFile  = "link"   // 层目录下记录短链接名的文件 写入它表示这一层已经完整导入
+)
+
+// layerPath 层在仓库中的目录 diffID 为解压后 tar 内容的 sha256.
+func layerPath(diffID string) string {
+	return filepath.Join(config.ImageStorePath, layersDir, diffID)
+}
+
+// layerExists 仓库中是否已经有这一层.
+func layerExists(diffID string) bool {
+	_, err := os.Stat(filepath.Join(layerPath(diffID), linkFile))
+	return err == nil
+}
+
+// layerLink 返回指向层内容的短路径 作为 overlay 的 lowerdir
+// 每层的绝对路径有 100 多个字符 层数多时会超过挂载参数一页的长度限制
+// 使用 l/<linkID> 这样的短路径 可以挂载更多的层.
+func layerLink(diffID string) (string, error) {
+	link, err := os.ReadFile(filepath.Join(layerPath(diffID), linkFile))
+	if err != nil {
+		return "", fmt.Errorf("read layer %s link fail err=%s", diffID, err)
+	}
+	return filepath.Join(config.ImageStorePath, linkDir, strings.TrimSpace(string(link))), nil
+}
+
+// storeLayer 把解压好的目录作为一层放入仓库 已经存在相同的层时直接丢弃 调用方需要持有仓库锁.
+func storeLayer(dir, diffID string) error {
+	if layerExists(diffID) {
+		return os.RemoveAll(dir)
+	}
+	path := layerPath(diffID)
+	// 上次导入中途失败留下的不完整的层
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("remove incomplete layer fail err=%s", err)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return fmt.Errorf("mkdir layer fail err=%s", err)
+	}
+	if err := os.Rename(dir, filepath.Join(path, diffDir)); err != nil {
+		return fmt.Errorf("move layer into store fail err=%s", err)
+	}
+	// 记下这一层的大小 images 命令不用每次遍历
+	layerSize(diffID)
+	if err := os.MkdirAll(filepath.Join(config.ImageStorePath, linkDir), 0700); err != nil {
+		return fmt.Errorf("mkdir layer link dir fail err=%s", err)
+	}
+	link, err := newLinkID()
+	if err != nil {
+		return err
+	}
+	target := filepath.Join("..", layersDir, diffID, diffDir)
+	if err := os.Symlink(target, filepath.Join(config.ImageStorePath, linkDir, link)); err != nil {
+		return fmt.Errorf("create layer link fail err=%s", err)
+	}
+	return os.WriteFile(filepath.Join(path, linkFile), []byte(link), 0644)
+}
+
+// newLinkID 生成 26 个字符的短链接名.
+func newLinkID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate layer link id fail err=%s", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
@@ -0,0 +1,147 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// overlayXattrPrefix overlay 内部使用的 xattr 不写入 tar 包.
+const overlayXattrPrefix = "trusted.overlay."
+
+// inode 用于识别硬链接.
+type inode struct {
+	dev, ino uint64
+}
+
+// WriteTar 把目录 dir 打包为 tar 写入 w
+// 保留属主 权限 xattr 符号链接 硬链接和设备文件
+// whiteout 为 true 时 dir 是 overlay 的读写层 其中的 0/0 字符设备和不透明目录
+// 转换为 .wh.<name> 和 .wh..wh..opq 这样通用的 whiteout 文件.
+func WriteTar(w io.Writer, dir string, whiteout bool) error {
+	tw := tar.NewWriter(w)
+	links := map[inode]string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("stat %s fail", rel)
+		}
+		if whiteout && fi.Mode()&os.ModeCharDevice != 0 && st.Rdev == 0 {
+			name := filepath.Join(filepath.Dir(rel), whiteoutPrefix+fi.Name())
+			return tw.WriteHeader(&tar.Header{
+				Name: name, Typeflag: tar.TypeReg, ModTime: fi.ModTime(), Format: tar.FormatPAX,
+			})
+		}
+
+		var target string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, target)
+		if err != nil {
+			return fmt.Errorf("tar header of %s fail err=%s", rel, err)
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		// 不使用宿主机上的用户名 只保留 uid gid
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.Format = tar.FormatPAX
+		if hdr.Typeflag == tar.TypeReg && st.Nlink > 1 {
+			key := inode{uint64(st.Dev), st.Ino}
+			if first, ok := links[key]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				links[key] = hdr.Name
+			}
+		}
+		xattrs, err := listXattrs(path)
+		if err != nil {
+			return err
+		}
+		opaque := false
+		for name, value := range xattrs {
+			if strings.HasPrefix(name, overlayXattrPrefix) {
+				opaque = opaque || (name == opaqueXattr && value == "y")
+				continue
+			}
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = map[string]string{}
+			}
+			hdr.PAXRecords[paxXattrPrefix+name] = value
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("write %s to tar fail err=%s", rel, err)
+			}
+		}
+		if whiteout && opaque {
+			return tw.WriteHeader(&tar.Header{
+				Name: filepath.Join(rel, whiteoutOpaque), Typeflag: tar.TypeReg, ModTime: fi.ModTime(), Format: tar.FormatPAX,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// listXattrs 读取文件的所有 xattr 不跟随符号链接.
+func listXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list xattr of %s fail err=%s", path, err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, fmt.Errorf("list xattr of %s fail err=%s", path, err)
+	}
+	xattrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		vsize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Lgetxattr(path, name, value); err != nil {
+			continue
+		}
+		xattrs[name] = string(value[:vsize])
+	}
+	return xattrs, nil
+}
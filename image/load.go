@@ -0,0 +1,438 @@
+package image
+
+import (
+	"crypto/sha256"
+	"duoker/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	dockerManifestFile = "manifest.json" // docker save 的清单文件
+	ociIndexFile       = "index.json"    // OCI image layout 的入口
+
+	mediaTypeOCIIndex   = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	annotationRefName   = "org.opencontainers.image.ref.name"
+	annotationImageName = "io.containerd.image.name"
+)
+
+// validDigest OCI 中使用的内容摘要 目前只支持 sha256.
+var validDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ociConfig OCI 和 docker 镜像配置文件中用到的部分.
+type ociConfig struct {
+	Config Config `json:"config"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// dockerManifest docker save 生成的 manifest.json 中的一项.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// descriptor OCI 中指向一个 blob 的描述.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+// ociIndex OCI 的 index.json 或者多平台镜像的 image index.
+type ociIndex struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// ociManifest OCI 镜像的清单.
+type ociManifest struct {
+	Config descriptor   `json:"config"`
+	Layers []descriptor `json:"layers"`
+}
+
+// Import 把一个根文件系统的 tar 包作为单层镜像导入到仓库中
+// tar 包可以是 gzip xz zstd 压缩的 path 为 - 时从标准输入读取.
+func Import(path, ref string) (*Image, error) {
+	name, err := ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return unpackImage("", []string{path}, nil, Config{}, []string{name})
+}
+
+// Load 导入 docker save 生成的 tar 包或者 OCI image layout
+// path 可以是 tar 包 (- 表示标准输入) 也可以是已经解开的目录
+// ref 不为空时用它作为镜像名 否则使用包中记录的镜像名.
+func Load(path, ref string) ([]*Image, error) {
+	var names []string
+	if ref != "" {
+		name, err := ParseReference(ref)
+		if err != nil {
+			return nil, err
+		}
+		names = []string{name}
+	}
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := path
+	if stat, err := os.Stat(path); path == "-" || err != nil || !stat.IsDir() {
+		// 先把整个包解开 再按其中的清单逐层导入
+		tmp, err := makeTmp("load-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		if err := extract(path, tmp); err != nil {
+			return nil, err
+		}
+		dir = tmp
+	}
+	if _, err := os.Stat(filepath.Join(dir, dockerManifestFile)); err == nil {
+		return loadDocker(dir, names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFile)); err == nil {
+		return loadOCI(dir, names)
+	}
+	return nil, fmt.Errorf("%s is neither a docker save archive nor an OCI image layout", path)
+}
+
+// loadDocker 导入 docker save 格式 manifest.json 中可能包含多个镜像.
+func loadDocker(dir string, names []string) ([]*Image, error) {
+	var manifests []dockerManifest
+	if err := readJSON(filepath.Join(dir, dockerManifestFile), &manifests); err != nil {
+		return nil, err
+	}
+	if names != nil && len(manifests) != 1 {
+		return nil, fmt.Errorf("archive contains %d images, cannot name them all %s", len(manifests), names[0])
+	}
+	var images []*Image
+	for _, m := range manifests {
+		id, diffIDs, cfg, err := readConfig(filepath.Join(dir, filepath.Clean("/"+m.Config)))
+		if err != nil {
+			return nil, err
+		}
+		var layers []string
+		for _, layer := range m.Layers {
+			layers = append(layers, filepath.Join(dir, filepath.Clean("/"+layer)))
+		}
+		tags := names
+		if tags == nil {
+			for _, t := range m.RepoTags {
+				name, err := ParseReference(t)
+				if err != nil {
+					return nil, err
+				}
+				tags = append(tags, name)
+			}
+		}
+		img, err := unpackImage(id, layers, diffIDs, cfg, tags)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// loadOCI 导入 OCI image layout
+// 多平台镜像只导入和当前机器架构相同的 linux 镜像.
+func loadOCI(dir string, names []string) ([]*Image, error) {
+	index := &ociIndex{}
+	if err := readJSON(filepath.Join(dir, ociIndexFile), index); err != nil {
+		return nil, err
+	}
+	if names != nil && len(index.Manifests) != 1 {
+		return nil, fmt.Errorf("layout contains %d images, cannot name them all %s", len(index.Manifests), names[0])
+	}
+	var images []*Image
+	for _, desc := range index.Manifests {
+		manifest, err := resolveManifest(dir, desc)
+		if err != nil {
+			return nil, err
+		}
+		configPath, err := blobPath(dir, manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+		id, diffIDs, cfg, err := readConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		var layers []string
+		for _, layer := range manifest.Layers {
+			path, err := blobPath(dir, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, path)
+		}
+		tags := names
+		if tags == nil {
+			name, err := ociRefName(desc)
+			if err != nil {
+				return nil, err
+			}
+			tags = []string{name}
+		}
+		img, err := unpackImage(id, layers, diffIDs, cfg, tags)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// resolveManifest 读取镜像清单 多平台镜像先选出当前平台.
+func resolveManifest(dir string, desc descriptor) (*ociManifest, error) {
+	path, err := blobPath(dir, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType == mediaTypeOCIIndex || desc.MediaType == mediaTypeDockerList {
+		index := &ociIndex{}
+		if err := readJSON(path, index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+				return resolveManifest(dir, m)
+			}
+		}
+		return nil, fmt.Errorf("no linux/%s image in %s", runtime.GOARCH, desc.Digest)
+	}
+	manifest := &ociManifest{}
+	if err := readJSON(path, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ociRefName 从 index.json 的注解中取得镜像名
+// ref.name 通常只有 tag 此时需要 containerd 记录的完整镜像名 或者由用户指定.
+func ociRefName(desc descriptor) (string, error) {
+	if name, ok := desc.Annotations[annotationImageName]; ok {
+		return ParseReference(name)
+	}
+	if name, ok := desc.Annotations[annotationRefName]; ok && strings.ContainsAny(name, ":/") {
+		return ParseReference(name)
+	}
+	return "", fmt.Errorf("image %s has no name, specify NAME[:TAG]", desc.Digest)
+}
+
+// blobPath OCI layout 中 blob 的路径.
+func blobPath(dir, digest string) (string, error) {
+	if !validDigest.MatchString(digest) {
+		return "", fmt.Errorf("invalid digest %s", digest)
+	}
+	return filepath.Join(dir, "blobs", "sha256", digest[len("sha256:"):]), nil
+}
+
+// readConfig 读取镜像的配置文件 镜像 ID 为配置文件的 sha256
+// 同时返回配置中记录的各层解压后的 sha256 用来跳过仓库中已有的层.
+func readConfig(path string) (string, []string, Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, Config{}, fmt.Errorf("read image config fail err=%s", err)
+	}
+	cfg := &ociConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return "", nil, Config{}, fmt.Errorf("parse image config fail err=%s", err)
+	}
+	var diffIDs []string
+	for _, d := range cfg.RootFS.DiffIDs {
+		if !validDigest.MatchString(d) {
+			return "", nil, Config{}, fmt.Errorf("invalid diff id %s", d)
+		}
+		diffIDs = append(diffIDs, strings.TrimPrefix(d, "sha256:"))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), diffIDs, cfg.Config, nil
+}
+
+// unpackImage 逐层解压并记录到仓库中 调用方需要持有仓库锁
+// diffIDs 为配置中记录的各层摘要 仓库中已经有的层不再解压 解压后的内容和记录不一致时报错
+// id 为空时根据各层的内容和配置生成 仓库中已经有相同的镜像时只添加镜像名.
+func unpackImage(id string, layers, diffIDs []string, cfg Config, names []string) (*Image, error) {
+	if id != "" {
+		if img, err := load(id); err == nil {
+			return img, tag(names, id)
+		}
+	}
+	if diffIDs != nil && len(diffIDs) != len(layers) {
+		return nil, fmt.Errorf("image config has %d layers but manifest has %d", len(diffIDs), len(layers))
+	}
+	tmp, err := makeTmp("image-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	img := &Image{ID: id, Created: time.Now(), Config: cfg}
+	for n, layer := range layers {
+		if diffIDs != nil && layerExists(diffIDs[n]) {
+			img.Layers = append(img.Layers, diffIDs[n])
+			continue
+		}
+		dir := filepath.Join(tmp, fmt.Sprintf("layer-%d", n))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, fmt.Errorf("mkdir layer fail err=%s", err)
+		}
+		diffID, err := unpackLayer(layer, dir)
+		if err != nil {
+			return nil, fmt.Errorf("unpack layer %s fail %s", filepath.Base(layer), err)
+		}
+		if diffIDs != nil && diffID != diffIDs[n] {
+			return nil, fmt.Errorf("layer %s digest mismatch: expect %s got %s", filepath.Base(layer), diffIDs[n], diffID)
+		}
+		if err := storeLayer(dir, diffID); err != nil {
+			return nil, err
+		}
+		img.Layers = append(img.Layers, diffID)
+	}
+	if img.ID == "" {
+		if img.ID, err = contentID(img.Layers, img.Config); err != nil {
+			return nil, err
+		}
+	}
+	return img, addImage(img, names)
+}
+
+// contentID 没有配置文件的镜像 (import commit) 根据各层和配置生成镜像 ID.
+func contentID(layers []string, cfg Config) (string, error) {
+	data, err := json.Marshal(struct {
+		Layers []string
+		Config Config
+	}{layers, cfg})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// addImage 写入镜像记录并添加镜像名 已经有相同 ID 的镜像时只添加镜像名 调用方需要持有仓库锁.
+func addImage(img *Image, names []string) error {
+	if _, err := load(img.ID); err == nil {
+		return tag(names, img.ID)
+	}
+	tmp, err := makeTmp("record-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := img.save(tmp); err != nil {
+		return fmt.Errorf("write image record fail err=%s", err)
+	}
+	if err := os.Rename(tmp, Dir(img.ID)); err != nil {
+		return fmt.Errorf("move image into store fail err=%s", err)
+	}
+	return tag(names, img.ID)
+}
+
+// unpackLayer 解压一层 返回解压后 tar 内容的 sha256.
+func unpackLayer(path, dir string) (string, error) {
+	f, err := openInput(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	if err := applyLayer(tee, dir); err != nil {
+		r.Close()
+		return "", err
+	}
+	// tar 结尾可能还有填充的块 读完才能得到完整的摘要
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		r.Close()
+		return "", err
+	}
+	if err := r.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extract 把 (可能压缩的) tar 包解到 dir.
+func extract(path, dir string) error {
+	f, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+	if err := applyLayer(r, dir); err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
+
+// openInput 打开输入文件 - 表示标准输入.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s fail err=%s", path, err)
+	}
+	return f, nil
+}
+
+// makeTmp 在仓库中创建临时目录 和仓库在同一个文件系统上 完成后可以直接 rename.
+func makeTmp(prefix string) (string, error) {
+	base := filepath.Join(config.ImageStorePath, tmpDir)
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return "", fmt.Errorf("mkdir image tmp dir fail err=%s", err)
+	}
+	dir, err := os.MkdirTemp(base, prefix)
+	if err != nil {
+		return "", fmt.Errorf("create image tmp dir fail err=%s", err)
+	}
+	return dir, nil
+}
+
+// readJSON 读取并解析 JSON 文件.
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s fail err=%s", filepath.Base(path), err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s fail err=%s", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package image
+
+import (
+	"duoker/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sizeFile 层目录下记录这一层大小的文件.
+const sizeFile = "size"
+
+// List 列出仓库中所有的镜像 按导入时间从新到旧排序.
+func List() ([]*Image, error) {
+	ids, err := listIDs()
+	if err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, id := range ids {
+		img, err := load(id)
+		if err != nil {
+			continue
+		}
+		images = append(images, img)
+	}
+	sort.Slice(images, func(a, b int) bool {
+		return images[a].Created.After(images[b].Created)
+	})
+	return images, nil
+}
+
+// Names 返回所有的镜像名 镜像 ID -> name:tag 列表.
+func Names() (map[string][]string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	names := map[string][]string{}
+	for name, id := range repos {
+		names[id] = append(names[id], name)
+	}
+	for _, list := range names {
+		sort.Strings(list)
+	}
+	return names, nil
+}
+
+// Size 镜像所有层的大小之和.
+func (img *Image) Size() int64 {
+	var size int64
+	for _, diffID := range img.Layers {
+		size += layerSize(diffID)
+	}
+	return size
+}
+
+// layerSize 层的大小 第一次计算后记录在层目录下.
+func layerSize(diffID string) int64 {
+	path := filepath.Join(layerPath(diffID), sizeFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			return size
+		}
+	}
+	var size int64
+	filepath.Walk(filepath.Join(layerPath(diffID), diffDir), func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	os.WriteFile(path, []byte(strconv.FormatInt(size, 10)), 0644)
+	return size
+}
+
+// Untag 删除一个镜像名 镜像本身保留.
+func Untag(ref string) error {
+	name, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	if _, ok := repos[name]; !ok {
+		return fmt.Errorf("no such image name %s", name)
+	}
+	delete(repos, name)
+	return saveRepositories(repos)
+}
+
+// ContainerRef 容器对镜像和层的引用
+// image 包不依赖 container 包 由调用方从容器状态中读取 在仓库锁内调用 这样不会漏掉正在创建的容器.
+type ContainerRef struct {
+	Name     string   // 容器名
+	ImageID  string   // 容器使用的镜像 ID
+	Lowerdir []string // 容器使用的层
+}
+
+// Remove 删除镜像和指向它的所有镜像名 然后回收不再被使用的层
+// 镜像被容器使用时 force 为 false 返回错误 为 true 时仍然删除 容器使用的层会保留到容器被删除
+// 返回被回收的层.
+func Remove(id string, force bool, refs func() ([]ContainerRef, error)) ([]string, error) {
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	containers, err := refs()
+	if err != nil {
+		return nil, err
+	}
+	var users, inUse []string
+	for _, c := range containers {
+		if c.ImageID == id {
+			users = append(users, c.Name)
+		}
+		inUse = append(inUse, c.Lowerdir...)
+	}
+	if len(users) > 0 && !force {
+		return nil, fmt.Errorf("image %s is used by container %s, remove the container first or use rmi -f",
+			id[:12], strings.Join(users, ", "))
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	for name, target := range repos {
+		if target == id {
+			delete(repos, name)
+		}
+	}
+	if err := saveRepositories(repos); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(Dir(id)); err != nil {
+		return nil, fmt.Errorf("remove image %s fail err=%s", id, err)
+	}
+	return gcLayers(inUse)
+}
+
+// GC 回收不再被任何镜像和容器使用的层 删除容器后调用
+// rmi -f 删除的镜像的层在最后一个使用它的容器被删除时回收.
+func GC(refs func() ([]ContainerRef, error)) ([]string, error) {
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	containers, err := refs()
+	if err != nil {
+		return nil, err
+	}
+	var inUse []string
+	for _, c := range containers {
+		inUse = append(inUse, c.Lowerdir...)
+	}
+	return gcLayers(inUse)
+}
+
+// CheckLowerdirs 在仓库锁内确认容器的层仍然存在
+// run 在保存容器状态之后调用 如果解析镜像之后镜像被 rmi 删除了 这里会返回错误
+// 否则之后的 rmi 一定能看到这个容器.
+func CheckLowerdirs(lowerdirs []string) error {
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, dir := range lowerdirs {
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("image layer %s is no longer available, the image may have been removed", dir)
+		}
+	}
+	return nil
+}
+
+// gcLayers 删除没有被任何镜像和容器引用的层 调用方需要持有仓库锁.
+func gcLayers(inUse []string) ([]string, error) {
+	keep := map[string]bool{}
+	images, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for _, img := range images {
+		for _, diffID := range img.Layers {
+			keep[diffID] = true
+		}
+	}
+	used := map[string]bool{}
+	for _, dir := range inUse {
+		used[dir] = true
+	}
+	entries, err := os.ReadDir(filepath.Join(config.ImageStorePath, layersDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var removed []string
+	for _, entry := range entries {
+		diffID := entry.Name()
+		if keep[diffID] {
+			continue
+		}
+		link, err := layerLink(diffID)
+		if err == nil && used[link] {
+			continue
+		}
+		if err == nil {
+			os.Remove(link)
+		}
+		if err := os.RemoveAll(layerPath(diffID)); err != nil {
+			return removed, fmt.Errorf("remove layer %s fail err=%s", diffID, err)
+		}
+		removed = append(removed, diffID)
+	}
+	return removed, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"duoker/image"
+	"duoker/units"
+	"duoker/workspace"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// importImage 把根文件系统的 tar 包导入为镜像
+// ./duoker import FILE|- NAME[:TAG]
+// 支持 gzip xz zstd 压缩.
+func importImage(args []string) error {
+	fs := cli.NewFlagSet("import", "import FILE|- NAME[:TAG]")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fs.UsageError()
+	}
+	img, err := image.Import(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Println(img.ID)
+	return nil
+}
+
+// loadImage 导入 docker save 生成的 tar 包或者 OCI image layout
+// ./duoker load [-i FILE|DIR] [NAME[:TAG]]
+// 默认从标准输入读取 包中没有记录镜像名时需要指定 NAME.
+func loadImage(args []string) error {
+	fs := cli.NewFlagSet("load", "load [-i FILE|DIR] [NAME[:TAG]]")
+	input := fs.String("i", "-", "read from tar archive file or OCI layout directory instead of STDIN")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fs.UsageError()
+	}
+	images, err := image.Load(*input, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		fmt.Printf("Loaded image: %s (%d layers)\n", img.ID, len(img.Layers))
+	}
+	return nil
+}
+
+// commitContainer 把容器的读写层保存为新的镜像
+// ./duoker commit NAME IMAGE[:TAG]
+// 新镜像在容器镜像的各层之上增加一层 和 docker commit 一样 配置中的 Cmd Env WorkingDir User 取自容器.
+func commitContainer(args []string) error {
+	fs := cli.NewFlagSet("commit", "commit NAME IMAGE[:TAG]")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fs.UsageError()
+	}
+	info, err := container.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if info.ImageID == "" {
+		return fmt.Errorf("container %s was created from directory %s, not an image in the image store", info.Name, info.Image)
+	}
+	parent, err := image.Get(info.ImageID)
+	if err != nil {
+		return fmt.Errorf("image of container %s: %s", info.Name, err)
+	}
+	cfg := parent.Config
+	// Entrypoint 由镜像配置保留 Cmd 只取它后面的部分 否则新镜像会执行两次 Entrypoint
+	cfg.Cmd = info.Args
+	// 容器的环境变量已经包括了镜像的 Env 和 run -e --env-file 主机名因容器而异 不保存
+	cfg.Env = withoutEnv(info.Env, "HOSTNAME")
+	if info.WorkingDir != "" {
+		cfg.WorkingDir = info.WorkingDir
+	}
+	cfg.User = info.User
+	img, err := image.Commit(parent, workspace.UpperDir(info.Name), cfg, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Println(img.ID)
+	return nil
+}
+
+// listImages 列出镜像仓库中的镜像 一个镜像名一行 没有镜像名的镜像显示为 <none>
+// ./duoker images [--format json].
+func listImages(args []string) error {
+	fs := cli.NewFlagSet("images", "images [OPTIONS]")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args)
+
+	images, err := image.List()
+	if err != nil {
+		return err
+	}
+	names, err := image.Names()
+	if err != nil {
+		return err
+	}
+	type imageRow struct {
+		Repository string    `json:"repository"`
+		Tag        string    `json:"tag"`
+		ID         string    `json:"id"`
+		Layers     int       `json:"layers"`
+		Size       int64     `json:"size"`
+		Created    time.Time `json:"created"`
+	}
+	rows := []imageRow{}
+	for _, img := range images {
+		refs := names[img.ID]
+		if len(refs) == 0 {
+			refs = []string{"<none>:<none>"}
+		}
+		size := img.Size()
+		for _, ref := range refs {
+			i := strings.LastIndexByte(ref, ':')
+			rows = append(rows, imageRow{ref[:i], ref[i+1:], img.ID, len(img.Layers), size, img.Created})
+		}
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tTAG\tIMAGE ID\tLAYERS\tSIZE\tCREATED")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", row.Repository, row.Tag, row.ID[:12],
+				row.Layers, units.HumanSize(row.Size), row.Created.Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %s", *format)
+	}
+}
+
+// rmi 删除镜像
+// ./duoker rmi [-f] IMAGE...
+// 按镜像名删除时只去掉这个名字 最后一个名字被去掉时才删除镜像 按镜像 ID 删除时去掉所有名字
+// 还有容器使用的镜像需要 -f 才能删除 容器使用的层会保留到容器被删除.
+func rmi(args []string) error {
+	fs := cli.NewFlagSet("rmi", "rmi [-f] IMAGE...")
+	force := fs.Bool("f", false, "force the removal of an image used by containers or with multiple names")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fs.UsageError()
+	}
+	for _, ref := range fs.Args() {
+		if err := rmImage(ref, *force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rmImage 删除一个镜像名或者镜像.
+func rmImage(ref string, force bool) error {
+	img, err := image.Get(ref)
+	if err != nil {
+		return err
+	}
+	names, err := image.Names()
+	if err != nil {
+		return err
+	}
+	refs := names[img.ID]
+	name, err := image.ParseReference(ref)
+	byName := err == nil && contains(refs, name)
+	if byName && len(refs) > 1 {
+		if err := image.Untag(name); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", name)
+		return nil
+	}
+	if !byName && len(refs) > 1 && !force {
+		return fmt.Errorf("image %s is referenced by multiple names %s, remove them by name or use rmi -f",
+			img.ID[:12], strings.Join(refs, ", "))
+	}
+
+	layers, err := image.Remove(img.ID, force, containerRefs)
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		fmt.Printf("Untagged: %s\n", r)
+	}
+	fmt.Printf("Deleted: %s\n", img.ID)
+	for _, layer := range layers {
+		fmt.Printf("Deleted layer: %s\n", layer)
+	}
+	return nil
+}
+
+// containerRefs 所有容器对镜像和层的引用 由 image 包在仓库锁内调用.
+func containerRefs() ([]image.ContainerRef, error) {
+	infos, err := container.List()
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]image.ContainerRef, 0, len(infos))
+	for _, info := range infos {
+		refs = append(refs, image.ContainerRef{Name: info.Name, ImageID: info.ImageID, Lowerdir: info.Lowerdir})
+	}
+	return refs, nil
+}
+
+// contains 判断字符串是否在列表中.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"duoker/container"
+	"duoker/workspace"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// initContainer 容器内的 init 进程
+// 出错时会通过同步管道把错误报告给父进程.
+func initContainer(containerName string, args []string) error {
+	runtime.LockOSThread()
+	syncPipe, err := container.ChildSyncPipe()
+	if err != nil {
+		return err
+	}
+	defer syncPipe.Close()
+	// 通知父进程命名空间已经就绪
+	if err := syncPipe.Send(container.SyncNsReady); err != nil {
+		return err
+	}
+	// 等待父进程网络命名空间设置完毕
+	if err := syncPipe.Wait(container.SyncNetConfigured); err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		syncPipe.SendError(err)
+		return err
+	}
+	// 镜像在 run 时已经解析好 从容器状态中读取
+	info, err := container.Load(containerName)
+	if err != nil {
+		return fail(err)
+	}
+	// 此时已经加入了容器的 cgroup 在这里创建 cgroup 命名空间 容器内看到的 cgroup 根目录就是容器自己的 cgroup
+	// 命名空间只对当前线程生效 所以 init 一开始就锁定了线程 之后的挂载和 exec 都在这个线程中
+	if err := syscall.Unshare(unix.CLONE_NEWCGROUP); err != nil {
+		return fail(fmt.Errorf("unshare cgroup namespace fail %s", err))
+	}
+	// 容器有自己的 UTS 命名空间 设置主机名不会影响宿主机
+	if err := syscall.Sethostname([]byte(info.Hostname)); err != nil {
+		return fail(fmt.Errorf("set hostname fail %s", err))
+	}
+	if info.Domainname != "" {
+		if err := syscall.Setdomainname([]byte(info.Domainname)); err != nil {
+			return fail(fmt.Errorf("set domainname fail %s", err))
+		}
+	}
+	if err := workspace.SetMountNamespace(containerName, info.Lowerdir, append(info.Mounts, etcMounts(info)...)); err != nil {
+		return fail(fmt.Errorf("SetMntNamespace %s", err))
+	}
+	syscall.Chdir("/")
+	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+	if err := syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), ""); err != nil {
+		return fail(fmt.Errorf("mount proc fail %s", err))
+	}
+	if err := workspace.MountSysfs(); err != nil {
+		return fail(err)
+	}
+	if err := workspace.MaskPaths(info.MaskedPaths); err != nil {
+		return fail(err)
+	}
+	if err := workspace.ReadonlyPaths(info.ReadonlyPaths); err != nil {
+		return fail(err)
+	}
+	if info.Tty {
+		if err := setupConsole(syncPipe); err != nil {
+			return fail(err)
+		}
+	}
+	// 用户和组按容器内的 passwd 和 group 解析
+	user, err := container.LookupUser(info.User, container.PasswdPath, container.GroupPath)
+	if err != nil {
+		return fail(err)
+	}
+	// 和 docker 一样 工作目录不存在时自动创建
+	if err := os.MkdirAll(info.WorkingDir, 0755); err != nil {
+		return fail(fmt.Errorf("mkdir working directory %s fail %s", info.WorkingDir, err))
+	}
+	// 只读的根目录最后再设置 前面的挂载可能需要在根目录下创建挂载点
+	if info.ReadOnly {
+		if err := workspace.ReadonlyRootfs(); err != nil {
+			return fail(err)
+		}
+	}
+	if err := syscall.Chdir(info.WorkingDir); err != nil {
+		return fail(fmt.Errorf("chdir %s fail %s", info.WorkingDir, err))
+	}
+	env := info.Env
+	if _, ok := container.LookupEnv(env, "HOME"); !ok {
+		env = append(env, "HOME="+user.Home)
+	}
+	if err := setUser(user); err != nil {
+		return fail(err)
+	}
+	// 按容器进程的 PATH 在容器内查找命令 切换用户之后再查找 才能正确判断是否有执行权限
+	path, _ := container.LookupEnv(env, "PATH")
+	executable, err := container.LookPath(args[0], path)
+	if err != nil {
+		return fail(err)
+	}
+	if err := syncPipe.Send(container.SyncRootfsReady); err != nil {
+		return err
+	}
+	// exec 成功后不会返回 同步管道随之关闭
+	if err := syscall.Exec(executable, args, env); err != nil {
+		// 例如脚本的解释器不存在
+		code := container.ExitCannotExecute
+		if err == syscall.ENOENT {
+			code = container.ExitNotFound
+		}
+		return fail(&container.ExecError{Code: code, Msg: fmt.Sprintf("exec proc fail %s", err)})
+	}
+	return nil
+}
+
+// setupConsole 在容器的 /dev/pts 中分配伪终端 master 交给父进程转发 slave 作为标准输入输出和控制终端
+// 伪终端在容器内分配 tty 等命令才能在容器的 /dev/pts 中找到它.
+func setupConsole(syncPipe *container.SyncPipe) error {
+	console, err := container.NewConsole()
+	if err != nil {
+		return err
+	}
+	defer console.Close()
+	if err := workspace.BindConsole(console.Slave.Name()); err != nil {
+		return err
+	}
+	if err := syncPipe.SendFile(container.SyncConsole, console.Master); err != nil {
+		return err
+	}
+	return console.AttachSlave()
+}
+
+// setUser 切换到容器进程的用户 先设置附加组和组 最后设置用户 之后就没有权限再修改了.
+func setUser(user *container.ExecUser) error {
+	if err := syscall.Setgroups(user.Groups); err != nil {
+		return fmt.Errorf("setgroups fail %s", err)
+	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("setgid %d fail %s", user.Gid, err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("setuid %d fail %s", user.Uid, err)
+	}
+	return nil
+}
+
+// etcMounts 把 run 生成的 hostname hosts 和 resolv.conf bind 挂载到容器的 /etc 下
+// 用户用 -v 挂载了同一个路径时以用户的为准.
+func etcMounts(info *container.Info) []workspace.Mount {
+	var mounts []workspace.Mount
+	for _, name := range etcFiles {
+		dest := "/etc/" + name
+		used := false
+		for _, m := range info.Mounts {
+			if m.Destination == dest {
+				used = true
+				break
+			}
+		}
+		if used {
+			continue
+		}
+		mounts = append(mounts, workspace.Mount{
+			Source:      filepath.Join(container.Dir(info.Name), name),
+			Destination: dest,
+			Propagation: "rprivate",
+		})
+	}
+	return mounts
+}
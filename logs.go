@@ -0,0 +1,56 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"fmt"
+	"os"
+	"time"
+)
+
+// logs 输出容器的日志
+// ./duoker logs [--follow] [--tail N] [--since T] [--timestamps] NAME.
+func logs(args []string) error {
+	fs := cli.NewFlagSet("logs", "logs [--follow] [--tail N] [--since T] [--timestamps] NAME")
+	opts := container.LogReadOptions{}
+	fs.BoolVar(&opts.Follow, "follow", false, "follow log output")
+	fs.BoolVar(&opts.Follow, "f", false, "shorthand for --follow")
+	fs.IntVar(&opts.Tail, "tail", -1, "number of lines to show from the end of the logs (-1 for all)")
+	since := fs.String("since", "", "show logs since timestamp (e.g. 2006-01-02T15:04:05Z) or relative (e.g. 10m)")
+	fs.BoolVar(&opts.Timestamps, "timestamps", false, "show timestamps")
+	fs.BoolVar(&opts.Timestamps, "t", false, "shorthand for --timestamps")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fs.UsageError()
+	}
+	if *since != "" {
+		t, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		opts.Since = t
+	}
+	info, err := container.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	running := func() bool {
+		current, err := container.Load(info.Name)
+		return err == nil && current.Status == container.StatusRunning
+	}
+	return container.ReadLogs(info.Name, opts, os.Stdout, os.Stderr, running)
+}
+
+// parseSince 解析 --since 参数
+// 支持 RFC3339 格式的时间 或者 10m 1h 这种相对现在的时间.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since value %q", s)
+}
@@ -1,105 +1,76 @@
 package main
 
 import (
-	"duoker/config"
+	"duoker/cli"
 	"duoker/log"
-	"duoker/network"
-	"duoker/workspace"
 	"fmt"
 	"os"
-	"os/exec"
-	"syscall"
-	"time"
 )
 
-// ./duoker run containerName /bin/sh
+// version 版本号 发布时通过 -ldflags "-X main.version=v1.0.0" 指定.
+var version = "dev"
+
+// commands duoker 的子命令 init 和 shim 是 duoker 自己启动的内部子命令 不显示在帮助中.
+var commands = []*cli.Command{
+	{Name: "run", Short: "Create and run a new container", Run: run},
+	{Name: "ps", Short: "List containers", Run: ps},
+	{Name: "stop", Short: "Stop one or more running containers", Run: stop},
+	{Name: "kill", Short: "Kill one or more running containers", Run: kill},
+	{Name: "rm", Short: "Remove one or more containers", Run: rm},
+	{Name: "exec", Short: "Run a command in a running container", Run: execCommand},
+	{Name: "stats", Short: "Display resource usage of containers", Run: stats},
+	{Name: "logs", Short: "Fetch the logs of a container", Run: logs},
+	{Name: "commit", Short: "Create a new image from a container's changes", Run: commitContainer},
+	{Name: "export", Short: "Export a container's filesystem as a tar archive", Run: export},
+	{Name: "import", Short: "Import a tarball to create an image", Run: importImage},
+	{Name: "load", Short: "Load an image from a tar archive or OCI layout", Run: loadImage},
+	{Name: "images", Short: "List images", Run: listImages},
+	{Name: "rmi", Short: "Remove one or more images", Run: rmi},
+	{Name: "volume", Short: "Manage volumes", Subcommands: volumeSubcommands, Run: volumeCommand},
+	{Name: "shim", Hidden: true, Run: shimCommand},
+	{Name: "init", Hidden: true, Run: initCommand},
+}
 
 func main() {
-	switch os.Args[1] {
-	case "run":
-		// 首先进行网络初始化
-		//		1. 在宿主机上创建网桥
-		//		2. 为网桥配置基础信息 如 网段 子网地址等
-		// 		3. 为宿主机配置内网的 NAT
-		if err := network.Init(); err != nil {
-			log.Error("net work fail err=%s", err)
-			return
-		}
-		fmt.Println(config.Banner())
-		// 在一个新的命名空间
-		// 打印本进程和父进程的 Pid
-		fmt.Println("run pid ", os.Getpid(), "ppid", os.Getppid())
-		// 这里拿到的 initCmd 就是 duoker 进程连接
-		// 在后面还要执行一次我们编译好的这个 duoker 程序
-		initCmd, err := os.Readlink("/proc/self/exe")
-		if err != nil {
-			log.Error("get init process error %s", err)
-			return
-		}
-		os.Args[1] = "init"
-		cmd := exec.Command(initCmd, os.Args[1:]...)
-		// 启动一个新的命名空间 并进行配置
-		// syscall.CLONE_NEWUTS	对主机名进行隔离
-		// syscall.CLONE_NEWPID	对pid空间进行隔离
-		// syscall.CLONE_NEWNS	对mount命名空间进行隔离
-		// syscall.CLONE_NEWNET	对网络进行隔离
-		// syscall.CLONE_NEWIPC	对进程通信组件进行隔离（消息队列）
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
-				syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
-		}
-		// 获取当前的环境变量
-		// 配置标准输入输出 1
-		cmd.Env = os.Environ()
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	app := &cli.App{
+		Name:     "duoker",
+		Version:  version,
+		Short:    "A simple docker-like container runtime",
+		Commands: commands,
+		OnError: func(cmd *cli.Command, err error) {
+			log.Error("%s fail %s", cmd.Name, err)
+		},
+	}
+	os.Exit(app.Run(os.Args[1:]))
+}
 
-		// cmd.Run()	会等待命令结束
-		// cmd.Start()	不会等待命令结束
-		// 从上个版本的 cmd.Run() 变为 cmd.Start()
-		err = cmd.Start()
-		if err != nil {
-			fmt.Println(err)
-		}
-		// 等待子进程完全启动
-		time.Sleep(2 * time.Second)
+// execCommand exec 以容器内命令的退出码退出.
+func execCommand(args []string) error {
+	code, err := execContainer(args)
+	if err != nil || code != 0 {
+		return &cli.ExitError{Code: code, Err: err}
+	}
+	return nil
+}
 
-		// 创建 Veth Peer 连接到容器和宿主机的 Bridge
-		if err := network.ConfigDefaultNetworkInNewNet(cmd.Process.Pid); err != nil {
-			log.Error("config network fail %s", err)
-		}
+// shimCommand 由 run -d 启动 ./duoker shim NAME.
+func shimCommand(args []string) error {
+	if len(args) != 1 {
+		return cli.Usagef("shim NAME")
+	}
+	return shim(args[0])
+}
 
-		// 在这里等待子进程的结束 因为前面使用的 cmd.Start 执行的命令
-		cmd.Wait()
-		workspace.DelMntNamespace(os.Args[2])
-		return
-	case "init":
-		var (
-			containerName = os.Args[2]
-			cmd           = os.Args[3]
-		)
-		log.Info("Wait  SIGUSR2 signal arrived ....")
-		// 等待父进程网络命名空间设置完毕
-		// 这里 WaitParentSetNewNet() 中使用 channel 阻塞了一个信号 等待父进程设置完之后再通知
-		network.WaitParentSetNewNet()
-		if err := workspace.SetMountNamespace(containerName); err != nil {
-			log.Error("SetMntNamespace %s", err)
-			return
-		}
-		syscall.Chdir("/")
-		defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-		syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-		err := syscall.Exec(cmd, os.Args[3:], os.Environ())
-		if err != nil {
-			log.Error("exec proc fail %s", err)
-			return
-		}
-		log.Error("forever not  exec it ")
-		return
-	default:
-		log.Error("not valid cmd")
+// initCommand 由 run 在新的命名空间中启动 ./duoker init NAME COMMAND [ARG...]
+// exec 成功后不会返回.
+func initCommand(args []string) error {
+	if len(args) < 2 {
+		return cli.Usagef("init NAME COMMAND [ARG...]")
+	}
+	if err := initContainer(args[0], args[1:]); err != nil {
+		return err
 	}
+	return fmt.Errorf("init returned without exec")
 }
 
 //
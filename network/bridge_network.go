@@ -5,12 +5,10 @@ import (
 	"fmt"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
-	"math/rand"
 	"net"
 	"os"
 	"os/exec"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -147,8 +145,9 @@ func (b *bridgeDriver) CreateNetwork(networkName string, subnet string, networkT
 	return NetMgr.Sync()
 }
 
-// CrateVeth 创建 veth 设备连接容器和宿主机的网络.
-func (b *bridgeDriver) CrateVeth(networkName string) (*netlink.Veth, *NetConf, error) {
+// CrateVeth 创建 veth 设备连接容器和宿主机的网络
+// endpointID 用于生成设备名 保证多个容器的 veth 不会重名.
+func (b *bridgeDriver) CrateVeth(networkName string, endpointID string) (*netlink.Veth, *NetConf, error) {
 	// 检查网络命名是否存在
 	// 要先检查 Veth 所在的网络是否被正常配置
 	if err := NetMgr.LoadConf(); err != nil {
@@ -177,7 +176,7 @@ func (b *bridgeDriver) CrateVeth(networkName string) (*netlink.Veth, *NetConf, e
 	//	NumRxQueues  int
 	// 等
 	la := netlink.NewLinkAttrs()
-	vethname := truncate(15, "veth-"+strconv.Itoa(10+int(rand.Int31n(10)))+"-"+networkConf.NetworkName)
+	vethname := truncate(15, "veth"+endpointID)
 	la.Name = vethname
 	la.MasterIndex = br.Attrs().Index
 	// 创建 veth 设备
@@ -191,11 +190,25 @@ func (b *bridgeDriver) CrateVeth(networkName string) (*netlink.Veth, *NetConf, e
 	}
 	//  `ip link set $link up`
 	if err := netlink.LinkSetUp(vethLink); err != nil {
+		netlink.LinkDel(vethLink)
 		return nil, nil, fmt.Errorf("error enabling interface for %s: %v", networkName, err)
 	}
 	return vethLink, networkConf, nil
 }
 
+// DeleteVeth 删除宿主机一侧的 veth 设备
+// 容器的网络命名空间销毁时内核会自动删除 veth 对 所以设备不存在时直接返回.
+func (b *bridgeDriver) DeleteVeth(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("delete veth fail name=%s err=%s", name, err)
+	}
+	return nil
+}
+
 func (b *bridgeDriver) setContainerIp(peerName string, pid int, containerIp net.IP, gateway *net.IPNet) error {
 	peerLink, err := netlink.LinkByName(peerName)
 	if err != nil {
@@ -0,0 +1,141 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// hostResolvConf 宿主机的 resolv.conf.
+	hostResolvConf = "/etc/resolv.conf"
+	// resolvedResolvConf 宿主机使用 systemd-resolved 时 /etc/resolv.conf 中只有 127.0.0.53
+	// 真正的上游 DNS 服务器记录在这个文件中.
+	resolvedResolvConf = "/run/systemd/resolve/resolv.conf"
+)
+
+// defaultNameservers 宿主机上没有容器可以使用的 DNS 服务器时 和 docker 一样使用 Google 的公共 DNS.
+var defaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+// DNSConfig run 命令中 --dns --dns-search --dns-option 指定的 DNS 配置 为空的部分继承宿主机的配置.
+type DNSConfig struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Validate 检查 DNS 服务器是否是合法的 IP 地址.
+func (c *DNSConfig) Validate() error {
+	for _, ns := range c.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return fmt.Errorf("invalid dns server %s, must be an IP address", ns)
+		}
+	}
+	return nil
+}
+
+// BuildHosts 生成容器的 /etc/hosts 包括 localhost 和容器自己的主机名
+// 指定了域名时容器的 IP 同时对应 hostname.domainname.
+func BuildHosts(ip, hostname, domainname string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("127.0.0.1\tlocalhost\n")
+	buf.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	buf.WriteString("fe00::0\tip6-localnet\n")
+	buf.WriteString("ff00::0\tip6-mcastprefix\n")
+	buf.WriteString("ff02::1\tip6-allnodes\n")
+	buf.WriteString("ff02::2\tip6-allrouters\n")
+	if ip != "" && domainname != "" {
+		fmt.Fprintf(&buf, "%s\t%s.%s %s\n", ip, hostname, domainname, hostname)
+	} else if ip != "" {
+		fmt.Fprintf(&buf, "%s\t%s\n", ip, hostname)
+	}
+	return buf.Bytes()
+}
+
+// HostResolvConf 读取宿主机的 resolv.conf 宿主机使用 systemd-resolved 时读取上游的配置.
+func HostResolvConf() ([]byte, error) {
+	data, err := os.ReadFile(hostResolvConf)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s fail err=%s", hostResolvConf, err)
+	}
+	ns, _, _ := parseResolvConf(data)
+	if len(ns) == 1 && ns[0] == "127.0.0.53" {
+		if upstream, err := os.ReadFile(resolvedResolvConf); err == nil {
+			return upstream, nil
+		}
+	}
+	return data, nil
+}
+
+// BuildResolvConf 根据宿主机的 resolv.conf 生成容器的 resolv.conf
+// 容器有自己的网络命名空间 宿主机上 127.0.0.0/8 和 ::1 的 DNS 服务器在容器中访问不到 所以跳过
+// cfg 中指定的部分覆盖宿主机的配置 --dns-search . 表示不使用搜索域.
+func BuildResolvConf(host []byte, cfg DNSConfig) []byte {
+	nameservers, search, options := parseResolvConf(host)
+	if len(cfg.Nameservers) > 0 {
+		nameservers = cfg.Nameservers
+	} else {
+		var usable []string
+		for _, ns := range nameservers {
+			if ip := net.ParseIP(ns); ip != nil && !ip.IsLoopback() {
+				usable = append(usable, ns)
+			}
+		}
+		nameservers = usable
+		if len(nameservers) == 0 {
+			nameservers = defaultNameservers
+		}
+	}
+	if len(cfg.Search) > 0 {
+		search = cfg.Search
+		if len(search) == 1 && search[0] == "." {
+			search = nil
+		}
+	}
+	if len(cfg.Options) > 0 {
+		options = cfg.Options
+	}
+
+	var buf bytes.Buffer
+	if len(search) > 0 {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(search, " "))
+	}
+	for _, ns := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&buf, "options %s\n", strings.Join(options, " "))
+	}
+	return buf.Bytes()
+}
+
+// parseResolvConf 解析 resolv.conf 中的 nameserver search (domain) 和 options.
+func parseResolvConf(data []byte) (nameservers, search, options []string) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			// 后出现的 search 和 domain 覆盖前面的
+			search = fields[1:]
+		case "options":
+			options = append(options, fields[1:]...)
+		}
+	}
+	return nameservers, search, options
+}
@@ -0,0 +1,46 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildHosts(t *testing.T) {
+	hosts := string(BuildHosts("192.168.0.5", "web", ""))
+	if !strings.HasPrefix(hosts, "127.0.0.1\tlocalhost\n") {
+		t.Fatalf("missing localhost entry:\n%s", hosts)
+	}
+	if !strings.HasSuffix(hosts, "192.168.0.5\tweb\n") {
+		t.Fatalf("missing container entry:\n%s", hosts)
+	}
+	hosts = string(BuildHosts("192.168.0.5", "web", "example.com"))
+	if !strings.HasSuffix(hosts, "192.168.0.5\tweb.example.com web\n") {
+		t.Fatalf("missing container entry with domain:\n%s", hosts)
+	}
+}
+
+func TestBuildResolvConf(t *testing.T) {
+	host := []byte("# generated\nnameserver 127.0.0.53\nnameserver ::1\nnameserver 10.0.0.2\nsearch example.com\noptions edns0\n")
+	tests := []struct {
+		name string
+		host []byte
+		cfg  DNSConfig
+		want string
+	}{
+		{"host", host, DNSConfig{},
+			"search example.com\nnameserver 10.0.0.2\noptions edns0\n"},
+		{"only loopback", []byte("nameserver 127.0.0.1\n"), DNSConfig{},
+			"nameserver 8.8.8.8\nnameserver 8.8.4.4\n"},
+		{"empty host", nil, DNSConfig{},
+			"nameserver 8.8.8.8\nnameserver 8.8.4.4\n"},
+		{"override", host, DNSConfig{Nameservers: []string{"1.1.1.1"}, Search: []string{"a.com", "b.com"}, Options: []string{"ndots:2"}},
+			"search a.com b.com\nnameserver 1.1.1.1\noptions ndots:2\n"},
+		{"no search", host, DNSConfig{Search: []string{"."}},
+			"nameserver 10.0.0.2\noptions edns0\n"},
+	}
+	for _, tt := range tests {
+		if got := string(BuildResolvConf(tt.host, tt.cfg)); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
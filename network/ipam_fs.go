@@ -5,8 +5,11 @@ import (
 	"duoker/log"
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"syscall"
 )
 
 // ipAmFs IP 分配管理.
@@ -26,6 +29,11 @@ var IpAmfs = &ipAmFs{
 }
 
 func (ipamfs *ipAmFs) SetIpUsed(subnet string) error {
+	unlock, err := ipamfs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	if err := ipamfs.loadConf(); err != nil {
 		return err
 	}
@@ -55,6 +63,11 @@ func (ipamfs *ipAmFs) SetIpUsed(subnet string) error {
 // AllocIp 遍历 bitmap 寻找还没有使用的 IP 号
 // 然后进行分配.
 func (ipamfs *ipAmFs) AllocIp(subnet string) (net.IP, error) {
+	unlock, err := ipamfs.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	if err := ipamfs.loadConf(); err != nil {
 		return nil, err
 	}
@@ -89,6 +102,11 @@ func (ipamfs *ipAmFs) AllocIp(subnet string) (net.IP, error) {
 
 // ReleaseIp 根据 IP 在子网中的索引 清除这个 IP 的使用记录.
 func (ipamfs *ipAmFs) ReleaseIp(subnet string, ip net.IP) error {
+	unlock, err := ipamfs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	if err := ipamfs.loadConf(); err != nil {
 		return err
 	}
@@ -127,6 +145,26 @@ func ipToUint32(ip net.IP) uint32 {
 	return binary.BigEndian.Uint32(ip)
 }
 
+// lock 对持久化文件加文件锁 返回解锁函数
+// run -d 启动的 shim 和 stop rm 等多个进程会同时分配和释放 IP 读取 修改和写回都要在锁内完成.
+func (ipamfs *ipAmFs) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(ipamfs.path), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir ipam dir fail err=%s", err)
+	}
+	f, err := os.OpenFile(ipamfs.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open ipam lock fail err=%s", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock ipam fail err=%s", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
 // loadConf 从持久化的文件中加载 ipam 的数据
 // 解析到内存的结构体中.
 func (ipamfs *ipAmFs) loadConf() error {
@@ -13,8 +13,9 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // NetConf 网络配置信息.
@@ -121,36 +122,87 @@ func Init() error {
 	return nil
 }
 
+// Endpoint 容器接入网络后的信息.
+type Endpoint struct {
+	Network string // 所在的网络名称
+	IP      net.IP // 分配给容器的 IP
+	Device  string // 宿主机一侧的 veth 设备名
+}
+
 // ConfigDefaultNetworkInNewNet 配置网络命名空间
 // 配置 veth对 将容器中的网络和宿主机的网络连在一起.
-func ConfigDefaultNetworkInNewNet(pid int) error {
+func ConfigDefaultNetworkInNewNet(pid int, endpointID string) (*Endpoint, error) {
 	// 为 veth 分配新的 IP
 	ip, err := IpAmfs.AllocIp(defaultSubnet)
 	if err != nil {
-		return fmt.Errorf("ipam alloc ip fail %s", err)
+		return nil, fmt.Errorf("ipam alloc ip fail %s", err)
+	}
+
+	// 后面的步骤失败时 调用方还没有记录 Endpoint 不会调用 ReleaseEndpoint 需要在这里回收
+	release := func(device string) {
+		if device != "" {
+			if err := BridgeDriver.DeleteVeth(device); err != nil {
+				log.Error("delete veth fail %s", err)
+			}
+		}
+		if err := IpAmfs.ReleaseIp(defaultSubnet, ip); err != nil {
+			log.Error("ipam release ip fail %s", err)
+		}
 	}
 
 	// 主机上创建 veth 设备,并连接到网桥上
-	vethLink, networkConf, err := BridgeDriver.CrateVeth(defaultNetName)
+	vethLink, networkConf, err := BridgeDriver.CrateVeth(defaultNetName, endpointID)
 	if err != nil {
-		return fmt.Errorf("create veth fail err=%s", err)
+		release("")
+		return nil, fmt.Errorf("create veth fail err=%s", err)
 	}
 	// 主机上设置子进程网络命名空间 配置
 	if err := BridgeDriver.setContainerIp(vethLink.PeerName, pid, ip, networkConf.BridgeIp); err != nil {
-		return fmt.Errorf("setContainerIp fail err=%s peername=%s pid=%d ip=%v conf=%+v", err, vethLink.PeerName, pid, ip, networkConf)
+		release(vethLink.Name)
+		return nil, fmt.Errorf("setContainerIp fail err=%s peername=%s pid=%d ip=%v conf=%+v", err, vethLink.PeerName, pid, ip, networkConf)
 	}
-	// 通知子进程设置完毕
+	// 由调用方通过同步管道通知子进程设置完毕
 	log.Debug("parent process set ip success")
-	return noticeSunProcessNetConfigFin(pid)
+	return &Endpoint{Network: defaultNetName, IP: ip, Device: vethLink.Name}, nil
 }
 
-func noticeSunProcessNetConfigFin(pid int) error {
-	return syscall.Kill(pid, syscall.SIGUSR2)
+// ReleaseEndpoint 容器退出后回收分配的 IP 并删除宿主机上的 veth 设备.
+func ReleaseEndpoint(ep *Endpoint) error {
+	if err := NetMgr.LoadConf(); err != nil {
+		return fmt.Errorf("netMgr loadConf fail %s", err)
+	}
+	netConf, ok := NetMgr.Storage[ep.Network]
+	if !ok {
+		return fmt.Errorf("name %s network is invalid", ep.Network)
+	}
+	if ep.Device != "" {
+		if err := BridgeDriver.DeleteVeth(ep.Device); err != nil {
+			return err
+		}
+	}
+	if ep.IP != nil {
+		if err := IpAmfs.ReleaseIp(netConf.IpRange.String(), ep.IP); err != nil {
+			return fmt.Errorf("ipam release ip fail %s", err)
+		}
+	}
+	return nil
 }
 
-func WaitParentSetNewNet() {
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGUSR2)
-	<-sigs
-	log.Info("Received SIGUSR2 signal, prepare run container")
+// EndpointStats 读取容器网卡收发的字节数
+// veth 两端的计数是相反的 宿主机一侧接收的就是容器发送的.
+func EndpointStats(device string) (rx, tx uint64, err error) {
+	read := func(name string) (uint64, error) {
+		data, err := os.ReadFile(filepath.Join("/sys/class/net", device, "statistics", name))
+		if err != nil {
+			return 0, fmt.Errorf("read %s statistics fail err=%s", device, err)
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+	if tx, err = read("rx_bytes"); err != nil {
+		return 0, 0, err
+	}
+	if rx, err = read("tx_bytes"); err != nil {
+		return 0, 0, err
+	}
+	return rx, tx, nil
 }
@@ -0,0 +1,102 @@
+// Package nsenter 用于 duoker exec 进入已经运行的容器
+// Go 程序启动后就是多线程的 而多线程进程不能通过 setns 切换 mnt 命名空间
+// 所以这里借助 cgo 的 constructor 在 Go 运行时启动之前完成 setns
+// 只需要在 main 中匿名导入这个包即可生效.
+package nsenter
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+// 和 Go 代码中的 PidEnv SyncEnv 保持一致
+#define DUOKER_EXEC_PID "DUOKER_EXEC_PID"
+#define DUOKER_EXEC_SYNC "DUOKER_EXEC_SYNC"
+
+// wait_cgroup 等待宿主机上的 exec 把本进程加入容器的 cgroup
+// 必须在 fork 之前完成 fork 出的进程会继承 cgroup 宿主机没有写入就关闭管道表示失败.
+static void wait_cgroup(void) {
+	const char *sync = getenv(DUOKER_EXEC_SYNC);
+	if (sync == NULL || *sync == '\0') {
+		return;
+	}
+	int fd = atoi(sync);
+	char c;
+	ssize_t n;
+	while ((n = read(fd, &c, 1)) < 0 && errno == EINTR) {
+	}
+	close(fd);
+	if (n != 1) {
+		fprintf(stderr, "nsenter: join container cgroup fail\n");
+		exit(1);
+	}
+}
+
+// enter_namespace 环境变量中带有容器 pid 时 依次加入该进程的命名空间
+// 和 network 包中 enterContainerNetns 一样 通过 /proc/<pid>/ns/* 拿到命名空间的文件描述符
+// 必须先把所有文件都打开 因为加入 mnt 命名空间之后看到的就是容器内的 /proc 了
+// pid 命名空间只对之后创建的子进程生效 并且加入后本进程不能再创建线程
+// 所以最后 fork 一次 由子进程继续启动 Go 运行时 本进程等待子进程并返回它的退出码
+// fork 之前先等待加入容器的 cgroup.
+__attribute__((constructor)) static void enter_namespace(void) {
+	const char *pid = getenv(DUOKER_EXEC_PID);
+	if (pid == NULL || *pid == '\0') {
+		return;
+	}
+	const char *namespaces[] = {"ipc", "uts", "net", "pid", "cgroup", "mnt"};
+	const int count = sizeof(namespaces) / sizeof(namespaces[0]);
+	int fds[sizeof(namespaces) / sizeof(namespaces[0])];
+	char path[64];
+	int i;
+
+	for (i = 0; i < count; i++) {
+		snprintf(path, sizeof(path), "/proc/%s/ns/%s", pid, namespaces[i]);
+		fds[i] = open(path, O_RDONLY | O_CLOEXEC);
+		if (fds[i] < 0) {
+			fprintf(stderr, "nsenter: open %s fail: %s\n", path, strerror(errno));
+			exit(1);
+		}
+	}
+	for (i = 0; i < count; i++) {
+		if (setns(fds[i], 0) < 0) {
+			fprintf(stderr, "nsenter: setns %s fail: %s\n", namespaces[i], strerror(errno));
+			exit(1);
+		}
+		close(fds[i]);
+	}
+
+	wait_cgroup();
+	pid_t child = fork();
+	if (child < 0) {
+		fprintf(stderr, "nsenter: fork fail: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child == 0) {
+		return;
+	}
+	int status;
+	while (waitpid(child, &status, 0) < 0) {
+		if (errno != EINTR) {
+			exit(1);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
+}
+*/
+import "C"
+
+// PidEnv 通过这个环境变量把目标容器的 pid 传给 constructor.
+const PidEnv = "DUOKER_EXEC_PID"
+
+// SyncEnv 通过这个环境变量把管道的文件描述符传给 constructor
+// constructor 在 fork 之前从管道读到一个字节后才继续 宿主机在此之前把它加入容器的 cgroup.
+const SyncEnv = "DUOKER_EXEC_SYNC"
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ps 列出所有容器 包括运行中和已经退出的
+// ./duoker ps [--format json].
+func ps(args []string) error {
+	fs := cli.NewFlagSet("ps", "ps [OPTIONS]")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args)
+
+	infos, err := container.List()
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		if infos == nil {
+			infos = []*container.Info{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "CONTAINER ID\tNAME\tIMAGE\tPID\tIP\tSTATUS\tCOMMAND\tCREATED")
+		for _, info := range infos {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%q\t%s\n",
+				info.ShortID(), info.Name, info.Image, info.Pid, info.IP, statusString(info),
+				strings.Join(info.Command, " "), info.Created.Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %s", *format)
+	}
+}
+
+// statusString 生成类似 docker ps 的状态描述.
+func statusString(info *container.Info) string {
+	switch info.Status {
+	case container.StatusRunning:
+		return fmt.Sprintf("Up %s", time.Since(info.Created).Round(time.Second))
+	case container.StatusExited:
+		if info.Finished.IsZero() {
+			return fmt.Sprintf("Exited (%d)", info.ExitCode)
+		}
+		return fmt.Sprintf("Exited (%d) %s ago", info.ExitCode, time.Since(info.Finished).Round(time.Second))
+	default:
+		return string(info.Status)
+	}
+}
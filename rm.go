@@ -0,0 +1,59 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"fmt"
+)
+
+// rm 删除已经退出的容器
+// ./duoker rm [-f] NAME...
+// -f 会先停止运行中的容器.
+func rm(args []string) error {
+	fs := cli.NewFlagSet("rm", "rm [-f] NAME...")
+	force := fs.Bool("f", false, "force the removal of a running container")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fs.UsageError()
+	}
+	for _, name := range fs.Args() {
+		if err := rmContainer(name, *force); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// rmContainer 删除一个容器 确保它的 IP 和 veth 已经回收.
+func rmContainer(name string, force bool) error {
+	info, err := container.Load(name)
+	if err != nil {
+		return err
+	}
+	if info.Status == container.StatusRunning {
+		if !force {
+			return fmt.Errorf("container %s is running, stop it first or use rm -f", name)
+		}
+		if err := stopContainer(info.Name, 0); err != nil {
+			return err
+		}
+	}
+	unlock, err := container.Lock(info.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// 加锁之后重新读取 避免和 supervisor 同时清理
+	info, err = container.Load(info.Name)
+	if err != nil {
+		return err
+	}
+	if starting(info) {
+		return fmt.Errorf("container %s is still starting, try again later", name)
+	}
+	if !info.Cleaned() {
+		teardown(info)
+	}
+	return removeContainer(info)
+}
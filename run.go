@@ -0,0 +1,666 @@
+package main
+
+import (
+	"duoker/cgroups"
+	"duoker/cli"
+	"duoker/config"
+	"duoker/container"
+	"duoker/image"
+	"duoker/log"
+	"duoker/network"
+	"duoker/units"
+	"duoker/volume"
+	"duoker/workspace"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// shimLogFile shim 进程自身的输出 保存在容器状态目录下.
+const shimLogFile = "shim.log"
+
+// run 创建并启动容器
+// ./duoker run [-d] [--rm] IMAGE containerName /bin/sh
+// 前台模式下 run 进程自己负责等待容器退出并清理
+// -d 模式下 fork 一个 shim 进程来管理容器 run 打印容器 ID 后直接返回.
+func run(args []string) error {
+	fs := cli.NewFlagSet("run", "run [OPTIONS] IMAGE NAME [COMMAND] [ARG...]")
+	detach := fs.Bool("d", false, "run container in background and print container ID")
+	autoRemove := fs.Bool("rm", false, "automatically remove the container when it exits")
+	logMaxSize := fs.String("log-max-size", "10m", "maximum size of the log file before it is rotated")
+	logMaxFiles := fs.Int("log-max-file", container.DefaultLogMaxFiles, "maximum number of log files to keep")
+	var volumes stringList
+	fs.Var(&volumes, "v", "bind mount a host path or named volume hostpath|name:containerpath[:ro][,rslave] (repeatable)")
+	var tmpfs stringList
+	fs.Var(&tmpfs, "tmpfs", "mount a tmpfs /path[:size=64m,mode=1777] (repeatable)")
+	readOnly := fs.Bool("read-only", false, "mount the container's root filesystem as read only")
+	maskedPaths := fs.String("masked-paths", strings.Join(workspace.DefaultMaskedPaths, ","),
+		"comma separated paths hidden inside the container, empty to disable")
+	readonlyPaths := fs.String("readonly-paths", strings.Join(workspace.DefaultReadonlyPaths, ","),
+		"comma separated paths made read only inside the container, empty to disable")
+	hostname := fs.String("hostname", "", "container host name, defaults to the container name or the short ID if the name is not a valid host name")
+	domainname := fs.String("domainname", "", "container NIS domain name")
+	var dns, dnsSearch, dnsOptions stringList
+	fs.Var(&dns, "dns", "set custom DNS servers (repeatable)")
+	fs.Var(&dnsSearch, "dns-search", "set custom DNS search domains, . for none (repeatable)")
+	fs.Var(&dnsOptions, "dns-option", "set DNS options (repeatable)")
+	var envs, envFiles stringList
+	fs.Var(&envs, "e", "set environment variables KEY=VALUE (repeatable)")
+	fs.Var(&envFiles, "env-file", "read environment variables from a file (repeatable)")
+	workDir := fs.String("w", "", "working directory inside the container")
+	user := fs.String("u", "", "user[:group] to run the command as, name or id inside the container")
+	rf := &resourceFlags{}
+	rf.register(fs.FlagSet)
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		return fs.UsageError()
+	}
+	imageRef, containerName := fs.Arg(0), fs.Arg(1)
+	// 容器名用作状态目录名 在访问磁盘之前检查
+	if !container.IsName(containerName) {
+		return fmt.Errorf("invalid container name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", containerName)
+	}
+	lowerdirs, img, err := image.Resolve(imageRef)
+	if err != nil {
+		return err
+	}
+	var imgConfig image.Config
+	if img != nil {
+		imgConfig = img.Config
+	}
+	command, cmdArgs, err := containerCommand(imgConfig, fs.Args()[2:])
+	if err != nil {
+		return err
+	}
+
+	// 首先进行网络初始化
+	//		1. 在宿主机上创建网桥
+	//		2. 为网桥配置基础信息 如 网段 子网地址等
+	// 		3. 为宿主机配置内网的 NAT
+	if err := network.Init(); err != nil {
+		return fmt.Errorf("net work fail err=%s", err)
+	}
+	// 容器名全局唯一 已经退出的同名容器需要先 rm 保存状态时会在容器锁内再检查一次
+	if old, err := container.Load(containerName); err == nil && old.Name == containerName {
+		return fmt.Errorf("container name %s is already in use by %s, remove it with duoker rm first", containerName, old.ShortID())
+	}
+	info, err := container.NewInfo(containerName, command)
+	if err != nil {
+		return err
+	}
+	info.Image = imageRef
+	info.Args = cmdArgs
+	var namedVolumes []*volume.Volume
+	for _, spec := range volumes {
+		m, err := workspace.ParseVolume(spec)
+		if err != nil {
+			return err
+		}
+		if m.Name != "" {
+			// 命名卷不存在时自动创建
+			v, _, err := volume.Create(m.Name)
+			if err != nil {
+				return err
+			}
+			m.Source = v.Mountpoint
+			namedVolumes = append(namedVolumes, v)
+		}
+		info.Mounts = append(info.Mounts, m)
+	}
+	for _, spec := range tmpfs {
+		m, err := workspace.ParseTmpfs(spec)
+		if err != nil {
+			return err
+		}
+		info.Mounts = append(info.Mounts, m)
+	}
+	info.ReadOnly = *readOnly
+	if info.MaskedPaths, err = parsePathList(*maskedPaths); err != nil {
+		return err
+	}
+	if info.ReadonlyPaths, err = parsePathList(*readonlyPaths); err != nil {
+		return err
+	}
+	// 容器名不能作为主机名时 (超过 64 个字符或者包含 _) 和 docker 一样使用短 ID
+	info.Hostname = containerName
+	if validateHostname(containerName) != nil {
+		info.Hostname = info.ShortID()
+	}
+	if *hostname != "" {
+		if err := validateHostname(*hostname); err != nil {
+			return err
+		}
+		info.Hostname = *hostname
+	}
+	if *domainname != "" {
+		if err := validateHostname(*domainname); err != nil {
+			return fmt.Errorf("invalid domainname %s", *domainname)
+		}
+		info.Domainname = *domainname
+	}
+	if len(dns) > 0 || len(dnsSearch) > 0 || len(dnsOptions) > 0 {
+		info.DNS = &network.DNSConfig{Nameservers: dns, Search: dnsSearch, Options: dnsOptions}
+		if err := info.DNS.Validate(); err != nil {
+			return err
+		}
+	}
+	info.Lowerdir = lowerdirs
+	if img != nil {
+		info.ImageID = img.ID
+	}
+	if info.LogMaxSize, err = units.ParseSize(*logMaxSize); err != nil {
+		return err
+	}
+	info.LogMaxFiles = *logMaxFiles
+	info.AutoRemove = *autoRemove
+	info.Tty = !*detach && isTerminal(os.Stdin) && isTerminal(os.Stdout)
+	if info.Env, err = container.BuildEnv(info.Hostname, info.Tty, imgConfig.Env, envFiles, envs); err != nil {
+		return err
+	}
+	info.WorkingDir = defaultWorkDir
+	if *workDir != "" {
+		if !filepath.IsAbs(*workDir) {
+			return fmt.Errorf("invalid working directory %s, must be absolute", *workDir)
+		}
+		info.WorkingDir = filepath.Clean(*workDir)
+	} else if imgConfig.WorkingDir != "" {
+		info.WorkingDir = imgConfig.WorkingDir
+	}
+	info.User = imgConfig.User
+	if *user != "" {
+		info.User = *user
+	}
+	if info.Resources, err = rf.resources(); err != nil {
+		return err
+	}
+	// 启动完成之前由 run 进程负责 启动失败时由它清理 stop 和 rm 据此判断容器是否还在启动中
+	info.ShimPid = os.Getpid()
+	if err := saveNew(info); err != nil {
+		return err
+	}
+	// 保存状态之后确认镜像没有在解析之后被 rmi 删除 之后的 rmi 能看到这个容器
+	if err := image.CheckLowerdirs(info.Lowerdir); err != nil {
+		info.Remove()
+		return err
+	}
+	// 命名卷同样需要确认创建之后没有被删除
+	if err := volume.Check(namedVolumes); err != nil {
+		info.Remove()
+		return err
+	}
+
+	if *detach {
+		if err := startShim(info); err != nil {
+			info.Remove()
+			return startError(err)
+		}
+		fmt.Println(info.ID)
+		return nil
+	}
+
+	fmt.Println(config.Banner())
+	cio, err := newForegroundIO(info)
+	if err != nil {
+		info.Remove()
+		return err
+	}
+	cmd, err := startContainer(info, cio)
+	if err != nil {
+		return startError(err)
+	}
+	// 和 docker 一样 前台运行时以容器进程的退出码退出
+	code, err := supervise(info, cmd, cio)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return &cli.ExitError{Code: code}
+	}
+	return nil
+}
+
+// startError 容器内的命令找不到或者不能执行时 和 docker 一样以 127 或 126 退出.
+func startError(err error) error {
+	var execErr *container.ExecError
+	if errors.As(err, &execErr) {
+		return &cli.ExitError{Code: execErr.Code, Err: err}
+	}
+	return err
+}
+
+// saveNew 第一次保存新容器的状态
+// 状态目录以容器名命名 在容器锁内再检查一次容器名 同时 run 同一个容器名时只有一个能成功.
+func saveNew(info *container.Info) error {
+	unlock, err := container.Lock(info.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if old, err := container.Load(info.Name); err == nil && old.Name == info.Name {
+		return fmt.Errorf("container name %s is already in use by %s, remove it with duoker rm first", info.Name, old.ShortID())
+	}
+	return info.Save()
+}
+
+// startShim 启动 shim 进程并等待它报告容器启动的结果
+// shim 使用新的会话 不会随终端关闭而退出.
+func startShim(info *container.Info) error {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return fmt.Errorf("get shim process error %s", err)
+	}
+	syncPipe, childPipe, err := container.NewSyncPipe()
+	if err != nil {
+		return err
+	}
+	defer syncPipe.Close()
+	logFile, err := os.OpenFile(filepath.Join(container.Dir(info.Name), shimLogFile),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		childPipe.Close()
+		return fmt.Errorf("open shim log fail %s", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(self, "shim", info.Name)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{childPipe}
+	err = cmd.Start()
+	childPipe.Close()
+	if err != nil {
+		return fmt.Errorf("start shim fail %s", err)
+	}
+	// shim 会一直运行到容器退出 这里不等待它
+	defer cmd.Process.Release()
+	return syncPipe.Wait(container.SyncStarted)
+}
+
+// shim 常驻的容器管理进程 由 run -d 启动
+// 负责启动容器 回收容器进程 记录退出码 并在容器退出后清理.
+func shim(containerName string) error {
+	syncPipe, err := container.ChildSyncPipe()
+	if err != nil {
+		return err
+	}
+	info, err := container.Load(containerName)
+	if err != nil {
+		syncPipe.SendError(err)
+		syncPipe.Close()
+		return err
+	}
+	cio, err := newDetachedIO(info)
+	if err == nil {
+		var cmd *exec.Cmd
+		if cmd, err = startContainer(info, cio); err == nil {
+			syncPipe.Send(container.SyncStarted)
+			syncPipe.Close()
+			_, err = supervise(info, cmd, cio)
+			return err
+		}
+	}
+	syncPipe.SendError(err)
+	syncPipe.Close()
+	return err
+}
+
+// startContainer 启动 init 子进程 并通过同步管道和子进程一步步完成容器的配置
+//  1. 子进程创建好命名空间后通知父进程 nsReady
+//  2. 父进程为子进程配置网络 完成后通知子进程 netConfigured
+//  3. 子进程准备好根文件系统后通知父进程 rootfsReady 然后 exec 用户命令
+//     使用伪终端时在这之前把容器内分配的伪终端交给父进程 console
+//  4. exec 成功后同步管道被关闭 失败则把错误发回父进程.
+func startContainer(info *container.Info, cio *containerIO) (*exec.Cmd, error) {
+	// 在一个新的命名空间
+	// 打印本进程和父进程的 Pid
+	fmt.Println("run pid ", os.Getpid(), "ppid", os.Getppid())
+	// 这里拿到的 initCmd 就是 duoker 进程连接
+	// 在后面还要执行一次我们编译好的这个 duoker 程序
+	initCmd, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, fmt.Errorf("get init process error %s", err)
+	}
+	syncPipe, childPipe, err := container.NewSyncPipe()
+	if err != nil {
+		return nil, err
+	}
+	defer syncPipe.Close()
+
+	cmd := exec.Command(initCmd, append([]string{"init", info.Name}, info.Command...)...)
+	// 启动一个新的命名空间 并进行配置
+	// syscall.CLONE_NEWUTS	对主机名进行隔离
+	// syscall.CLONE_NEWPID	对pid空间进行隔离
+	// syscall.CLONE_NEWNS	对mount命名空间进行隔离
+	// syscall.CLONE_NEWNET	对网络进行隔离
+	// syscall.CLONE_NEWIPC	对进程通信组件进行隔离（消息队列）
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+	}
+	// 获取当前的环境变量
+	// 配置标准输入输出 输出会记录到容器的日志文件中
+	cmd.Env = os.Environ()
+	cio.apply(cmd)
+	// 同步管道的子进程一端 在子进程中是 fd 3
+	cmd.ExtraFiles = []*os.File{childPipe}
+
+	// 先创建好 cgroup 并写入资源限制 子进程启动后再把它加进去
+	cgroup, err := newCgroup(info)
+	if err != nil {
+		childPipe.Close()
+		cio.close()
+		info.Remove()
+		return nil, err
+	}
+
+	// cmd.Run()	会等待命令结束
+	// cmd.Start()	不会等待命令结束
+	// 从上个版本的 cmd.Run() 变为 cmd.Start()
+	err = cmd.Start()
+	// 父进程中不再需要子进程的一端 关闭后子进程退出时才能读到 EOF
+	childPipe.Close()
+	if err != nil {
+		cio.close()
+		if cgroup != nil {
+			cgroup.Destroy()
+		}
+		info.Remove()
+		return nil, fmt.Errorf("start init process fail %s", err)
+	}
+	info.Pid = cmd.Process.Pid
+	info.ShimPid = os.Getpid()
+	// 启动失败时杀掉子进程 并清理已经创建的目录 网络和状态记录
+	fail := func(err error) (*exec.Cmd, error) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		cio.close()
+		teardown(info)
+		removeContainer(info)
+		return nil, err
+	}
+
+	// 等待子进程完全启动
+	if err := syncPipe.Wait(container.SyncNsReady); err != nil {
+		return fail(err)
+	}
+	// 子进程还没有 exec 用户命令 此时加入 cgroup 之后的所有进程都会受到限制
+	if cgroup != nil {
+		if err := cgroup.Apply(cmd.Process.Pid); err != nil {
+			syncPipe.SendError(err)
+			return fail(err)
+		}
+	}
+	// 创建 Veth Peer 连接到容器和宿主机的 Bridge
+	endpoint, err := network.ConfigDefaultNetworkInNewNet(cmd.Process.Pid, info.ID)
+	if err != nil {
+		err = fmt.Errorf("config network fail %s", err)
+		syncPipe.SendError(err)
+		return fail(err)
+	}
+	info.IP = endpoint.IP.String()
+	info.Network = endpoint.Network
+	info.Device = endpoint.Device
+	// init 会把这些文件 bind 挂载到容器的 /etc 下
+	if err := writeEtcFiles(info); err != nil {
+		syncPipe.SendError(err)
+		return fail(err)
+	}
+	if err := syncPipe.Send(container.SyncNetConfigured); err != nil {
+		return fail(err)
+	}
+	if info.Tty {
+		master, err := syncPipe.RecvFile(container.SyncConsole)
+		if err != nil {
+			return fail(err)
+		}
+		cio.setConsole(master)
+	}
+	if err := syncPipe.Wait(container.SyncRootfsReady); err != nil {
+		return fail(err)
+	}
+	if err := syncPipe.WaitExec(); err != nil {
+		return fail(err)
+	}
+	info.Status = container.StatusRunning
+	if err := info.Save(); err != nil {
+		log.Error("save container state fail %s", err)
+	}
+	cio.attach()
+	return cmd, nil
+}
+
+// containerCommand 根据镜像的 Entrypoint 和 Cmd 确定容器进程的命令
+// 和 docker 一样 run 指定的命令替换镜像的 Cmd 镜像有 Entrypoint 时命令作为它的参数
+// 同时返回不包括 Entrypoint 的部分 commit 时作为新镜像的 Cmd.
+func containerCommand(cfg image.Config, args []string) ([]string, []string, error) {
+	if len(args) == 0 {
+		args = cfg.Cmd
+	}
+	command := append(append([]string(nil), cfg.Entrypoint...), args...)
+	if len(command) == 0 {
+		return nil, nil, fmt.Errorf("no command specified and image has no default entrypoint or cmd")
+	}
+	return command, args, nil
+}
+
+// etcFiles 由 duoker 为每个容器生成的 /etc 下的文件 保存在容器的状态目录中.
+var etcFiles = []string{"hostname", "hosts", "resolv.conf"}
+
+// writeEtcFiles 在容器的状态目录下生成 hostname hosts 和 resolv.conf
+// hosts 中需要容器的 IP 所以在网络配置完成之后调用.
+func writeEtcFiles(info *container.Info) error {
+	hostResolv, err := network.HostResolvConf()
+	if err != nil {
+		return err
+	}
+	var dns network.DNSConfig
+	if info.DNS != nil {
+		dns = *info.DNS
+	}
+	contents := map[string][]byte{
+		"hostname":    []byte(info.Hostname + "\n"),
+		"hosts":       network.BuildHosts(info.IP, info.Hostname, info.Domainname),
+		"resolv.conf": network.BuildResolvConf(hostResolv, dns),
+	}
+	for _, name := range etcFiles {
+		path := filepath.Join(container.Dir(info.Name), name)
+		if err := os.WriteFile(path, contents[name], 0644); err != nil {
+			return fmt.Errorf("write %s fail err=%s", path, err)
+		}
+	}
+	return nil
+}
+
+// validateHostname 检查主机名 和 sethostname 一样最长 64 个字符 只能包含字母 数字 - 和 .
+func validateHostname(hostname string) error {
+	if len(hostname) > 64 {
+		return fmt.Errorf("invalid hostname %s, must be at most 64 characters", hostname)
+	}
+	for _, c := range hostname {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return fmt.Errorf("invalid hostname %s, only letters, digits, - and . are allowed", hostname)
+		}
+	}
+	return nil
+}
+
+// supervise 等待容器进程退出 清理容器并记录退出状态 返回容器进程的退出码.
+func supervise(info *container.Info, cmd *exec.Cmd, cio *containerIO) (int, error) {
+	// 在这里等待子进程的结束 因为前面使用的 cmd.Start 执行的命令
+	cmd.Wait()
+	cio.close()
+	code := exitCode(cmd.ProcessState)
+	return code, finish(info.Name, code)
+}
+
+// finish 容器进程退出后的统一收尾 清理容器并把状态记录为已退出
+// supervisor stop kill 都可能调用 通过文件锁和 Finished 字段保证只清理一次.
+func finish(containerName string, code int) error {
+	unlock, err := container.Lock(containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	info, err := container.Load(containerName)
+	if err != nil {
+		return err
+	}
+	if info.Cleaned() {
+		return nil
+	}
+	teardown(info)
+	// --rm 启动的容器退出后直接删除
+	if info.AutoRemove {
+		return removeContainer(info)
+	}
+	info.Status = container.StatusExited
+	info.ExitCode = code
+	info.Finished = time.Now()
+	return info.Save()
+}
+
+// teardown 容器退出后释放它占用的资源
+// 卸载 overlay 回收 IP 删除 veth 设备 读写层保留到容器被删除.
+func teardown(info *container.Info) {
+	if err := workspace.UnmountRootfs(info.Name); err != nil {
+		log.Error("unmount rootfs fail %s", err)
+	}
+	if cgroup, err := cgroups.New(info.Name); err == nil {
+		if err := cgroup.Destroy(); err != nil {
+			log.Error("destroy cgroup fail %s", err)
+		}
+	}
+	if info.Network != "" {
+		ep := &network.Endpoint{Network: info.Network, IP: net.ParseIP(info.IP), Device: info.Device}
+		if err := network.ReleaseEndpoint(ep); err != nil {
+			log.Error("release network endpoint fail %s", err)
+		}
+	}
+}
+
+// exitCode 获取容器进程的退出码
+// 被信号杀死时和 shell 一样返回 128+信号值.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// removeContainer 删除已经退出的容器 包括读写层和状态记录.
+func removeContainer(info *container.Info) error {
+	if err := workspace.DelMntNamespace(info.Name); err != nil {
+		return fmt.Errorf("clean overlayfs fail %s", err)
+	}
+	if err := info.Remove(); err != nil {
+		return err
+	}
+	// 回收 rmi -f 删除的镜像留下的层
+	if _, err := image.GC(containerRefs); err != nil {
+		log.Error("gc image layers fail %s", err)
+	}
+	return nil
+}
+
+// newCgroup 为容器创建 cgroup 并写入资源限制
+// 宿主机上找不到 cgroup 时不创建 cgroup 但如果指定了资源限制则返回错误.
+func newCgroup(info *container.Info) (cgroups.Manager, error) {
+	cgroup, err := cgroups.New(info.Name)
+	if err != nil {
+		if !info.Resources.Empty() {
+			return nil, err
+		}
+		log.Debug("skip cgroup %s", err)
+		return nil, nil
+	}
+	if err := cgroup.Set(info.Resources); err != nil {
+		cgroup.Destroy()
+		return nil, fmt.Errorf("set cgroup fail %s", err)
+	}
+	return cgroup, nil
+}
+
+// resourceFlags run 命令中资源限制相关的参数.
+type resourceFlags struct {
+	memory     string
+	memorySwap string
+	cpus       float64
+	cpuShares  int64
+	cpuWeight  int64
+	cpusetCPUs string
+	pidsLimit  int64
+}
+
+func (rf *resourceFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&rf.memory, "memory", "", "memory limit (e.g. 512m)")
+	fs.StringVar(&rf.memory, "m", "", "shorthand for --memory")
+	fs.StringVar(&rf.memorySwap, "memory-swap", "", "total memory plus swap limit, -1 for unlimited swap")
+	fs.Float64Var(&rf.cpus, "cpus", 0, "number of CPUs (e.g. 1.5)")
+	fs.Int64Var(&rf.cpuShares, "cpu-shares", 0, "CPU shares (relative weight, default 1024), converted to cpu.weight on cgroup v2")
+	fs.Int64Var(&rf.cpuWeight, "cpu-weight", 0, "cgroup v2 cpu.weight 1-10000 (default 100), converted to cpu.shares on cgroup v1")
+	fs.StringVar(&rf.cpusetCPUs, "cpuset-cpus", "", "CPUs in which to allow execution (e.g. 0-3,5)")
+	fs.Int64Var(&rf.pidsLimit, "pids-limit", 0, "maximum number of processes")
+}
+
+// resources 解析并检查资源限制参数.
+func (rf *resourceFlags) resources() (*cgroups.Resources, error) {
+	r := &cgroups.Resources{
+		CPUs:       rf.cpus,
+		CPUShares:  rf.cpuShares,
+		CPUWeight:  rf.cpuWeight,
+		CPUSetCPUs: rf.cpusetCPUs,
+		PidsLimit:  rf.pidsLimit,
+	}
+	var err error
+	if rf.memory != "" {
+		if r.Memory, err = units.ParseSize(rf.memory); err != nil {
+			return nil, err
+		}
+	}
+	if rf.memorySwap == "-1" {
+		r.MemorySwap = -1
+	} else if rf.memorySwap != "" {
+		if r.MemorySwap, err = units.ParseSize(rf.memorySwap); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// stringList 可以重复指定的字符串参数 例如 -v a:/a -v b:/b.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parsePathList 解析逗号分隔的容器内路径 路径必须是绝对路径.
+func parsePathList(list string) ([]string, error) {
+	var paths []string
+	for _, path := range strings.Split(list, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid path %s, must be absolute", path)
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	return paths, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"duoker/cgroups"
+	"duoker/cli"
+	"duoker/container"
+	"duoker/log"
+	"duoker/network"
+	"duoker/units"
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// statsInterval 两次采样的间隔 CPU 使用率按这段时间内的增量计算.
+const statsInterval = time.Second
+
+// containerStats 一个容器的资源使用情况
+// 网络的收发是从容器的角度统计的.
+type containerStats struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"` // 没有限制时为宿主机的内存总量
+	MemoryPercent float64 `json:"memoryPercent"`
+	Pids          uint64  `json:"pids"`
+	NetRx         uint64  `json:"netRx"`
+	NetTx         uint64  `json:"netTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+}
+
+// statsSample 一次采样的原始数据.
+type statsSample struct {
+	info   *container.Info
+	cgroup *cgroups.Stats
+	rx, tx uint64
+	at     time.Time
+}
+
+// stats 显示容器的资源使用情况 默认像 top 一样持续刷新
+// ./duoker stats [--no-stream] [--format table|json] [NAME...]
+// 不指定容器时显示所有运行中的容器.
+func stats(args []string) error {
+	fs := cli.NewFlagSet("stats", "stats [OPTIONS] [NAME...]")
+	noStream := fs.Bool("no-stream", false, "print the first result and exit")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args)
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+	names := fs.Args()
+
+	prev, err := sampleStats(names, true)
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(statsInterval)
+		cur, err := sampleStats(names, false)
+		if err != nil {
+			return err
+		}
+		result := computeStats(prev, cur)
+		if *format == "json" {
+			err = printStatsJSON(result, *noStream)
+		} else {
+			err = printStatsTable(result, !*noStream)
+		}
+		if err != nil || *noStream {
+			return err
+		}
+		// 指定的容器都已经退出
+		if len(names) > 0 && len(cur) == 0 {
+			return nil
+		}
+		prev = cur
+	}
+}
+
+// sampleStats 对容器进行一次采样
+// strict 为 true 时指定的容器不在运行会返回错误 否则忽略已经退出的容器.
+func sampleStats(names []string, strict bool) ([]*statsSample, error) {
+	var infos []*container.Info
+	if len(names) == 0 {
+		all, err := container.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range all {
+			if info.Status == container.StatusRunning {
+				infos = append(infos, info)
+			}
+		}
+	} else {
+		for _, name := range names {
+			info, err := loadRunning(name)
+			if err != nil {
+				if strict {
+					return nil, err
+				}
+				continue
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	var samples []*statsSample
+	for _, info := range infos {
+		sample := &statsSample{info: info, cgroup: &cgroups.Stats{}, at: time.Now()}
+		if cgroup, err := cgroups.New(info.Name); err == nil {
+			if s, err := cgroup.Stats(); err == nil {
+				sample.cgroup = s
+			} else {
+				log.Debug("read cgroup stats of %s fail %s", info.Name, err)
+			}
+		}
+		if info.Device != "" {
+			if rx, tx, err := network.EndpointStats(info.Device); err == nil {
+				sample.rx, sample.tx = rx, tx
+			}
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// computeStats 根据两次采样计算资源使用情况
+// CPU 使用率和 docker 一样 100% 表示占满一个核.
+func computeStats(prev, cur []*statsSample) []*containerStats {
+	before := map[string]*statsSample{}
+	for _, s := range prev {
+		before[s.info.ID] = s
+	}
+	hostMemory := hostMemoryTotal()
+	result := []*containerStats{}
+	for _, s := range cur {
+		cs := &containerStats{
+			ID:          s.info.ShortID(),
+			Name:        s.info.Name,
+			MemoryUsage: s.cgroup.MemoryUsage,
+			MemoryLimit: s.cgroup.MemoryLimit,
+			Pids:        s.cgroup.Pids,
+			NetRx:       s.rx,
+			NetTx:       s.tx,
+			BlockRead:   s.cgroup.BlkioRead,
+			BlockWrite:  s.cgroup.BlkioWrite,
+		}
+		if p, ok := before[s.info.ID]; ok && s.cgroup.CPUUsage >= p.cgroup.CPUUsage {
+			if elapsed := s.at.Sub(p.at); elapsed > 0 {
+				cs.CPUPercent = float64(s.cgroup.CPUUsage-p.cgroup.CPUUsage) / float64(elapsed) * 100
+			}
+		}
+		if cs.MemoryLimit == 0 || (hostMemory > 0 && cs.MemoryLimit > hostMemory) {
+			cs.MemoryLimit = hostMemory
+		}
+		if cs.MemoryLimit > 0 {
+			cs.MemoryPercent = float64(cs.MemoryUsage) / float64(cs.MemoryLimit) * 100
+		}
+		result = append(result, cs)
+	}
+	return result
+}
+
+// hostMemoryTotal 宿主机的内存总量.
+func hostMemoryTotal() uint64 {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0
+	}
+	return uint64(info.Totalram) * uint64(info.Unit)
+}
+
+// printStatsTable 以表格输出 refresh 为 true 时先清屏 实现类似 top 的刷新效果.
+func printStatsTable(result []*containerStats, refresh bool) error {
+	if refresh {
+		fmt.Print("\033[2J\033[H")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS")
+	for _, cs := range result {
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			cs.ID, cs.Name, cs.CPUPercent,
+			units.HumanSize(int64(cs.MemoryUsage)), units.HumanSize(int64(cs.MemoryLimit)), cs.MemoryPercent,
+			units.HumanSize(int64(cs.NetRx)), units.HumanSize(int64(cs.NetTx)),
+			units.HumanSize(int64(cs.BlockRead)), units.HumanSize(int64(cs.BlockWrite)),
+			cs.Pids)
+	}
+	return w.Flush()
+}
+
+// printStatsJSON 以 JSON 输出
+// 持续刷新时每次输出一行 方便脚本逐行读取.
+func printStatsJSON(result []*containerStats, indent bool) error {
+	enc := json.NewEncoder(os.Stdout)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(result)
+}
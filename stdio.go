@@ -0,0 +1,123 @@
+package main
+
+import (
+	"duoker/container"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// containerIO 容器进程的标准输入输出
+// 输出除了按需显示在终端上 都会以 JSON 行的格式记录到容器的日志文件中.
+type containerIO struct {
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	tty     bool               // 容器使用伪终端
+	console *container.Console // 容器内分配的伪终端 init 交给父进程之后才不为空
+	logFile *container.LogFile
+	streams []io.Closer
+	copied  chan struct{} // 伪终端的输出转发结束
+	restore func()        // 恢复宿主机终端的设置
+}
+
+// openLog 打开容器的日志文件.
+func openLog(info *container.Info) (*container.LogFile, error) {
+	return container.OpenLogFile(container.LogPath(info.Name), info.LogMaxSize, info.LogMaxFiles)
+}
+
+// newDetachedIO 后台运行的容器 没有输入 输出只写入日志.
+func newDetachedIO(info *container.Info) (*containerIO, error) {
+	logFile, err := openLog(info)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := logFile.Stream("stdout"), logFile.Stream("stderr")
+	return &containerIO{
+		stdout:  stdout,
+		stderr:  stderr,
+		logFile: logFile,
+		streams: []io.Closer{stdout, stderr},
+	}, nil
+}
+
+// newForegroundIO 前台运行的容器 输出同时写到终端和日志
+// 在终端中运行时分配伪终端 保证 /bin/sh 等交互式程序正常工作.
+func newForegroundIO(info *container.Info) (*containerIO, error) {
+	logFile, err := openLog(info)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := logFile.Stream("stdout"), logFile.Stream("stderr")
+	cio := &containerIO{
+		stdin:   os.Stdin,
+		stdout:  io.MultiWriter(os.Stdout, stdout),
+		stderr:  io.MultiWriter(os.Stderr, stderr),
+		logFile: logFile,
+		streams: []io.Closer{stdout, stderr},
+	}
+	if info.Tty {
+		cio.tty = true
+		cio.streams = []io.Closer{stdout}
+	}
+	return cio, nil
+}
+
+// apply 为容器进程配置标准输入输出
+// 使用伪终端时容器进程成为新会话的首进程 伪终端由 init 在容器的 /dev/pts 中分配 并设置为控制终端.
+func (c *containerIO) apply(cmd *exec.Cmd) {
+	if !c.tty {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = c.stdin, c.stdout, c.stderr
+		return
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
+// setConsole 收到 init 分配的伪终端 master 后调用 开始转发伪终端的输出.
+func (c *containerIO) setConsole(master *os.File) {
+	c.console = &container.Console{Master: master}
+	c.console.ResizeFrom(os.Stdin)
+	// 容器内所有进程都关闭 slave 之后读 master 才会结束
+	c.copied = make(chan struct{})
+	go func() {
+		io.Copy(c.stdout, c.console.Master)
+		close(c.copied)
+	}()
+}
+
+// attach 容器 exec 成功后 把终端的输入转发给伪终端.
+func (c *containerIO) attach() {
+	if c.console == nil {
+		return
+	}
+	if restore, err := container.SetRawTerminal(os.Stdin); err == nil {
+		c.restore = restore
+	}
+	go io.Copy(c.console.Master, os.Stdin)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		for range winch {
+			c.console.ResizeFrom(os.Stdin)
+		}
+	}()
+}
+
+// close 容器进程退出后调用 等待输出转发结束并关闭日志.
+func (c *containerIO) close() {
+	if c.console != nil {
+		if c.copied != nil {
+			<-c.copied
+		}
+		if c.restore != nil {
+			c.restore()
+		}
+		c.console.Close()
+	}
+	for _, s := range c.streams {
+		s.Close()
+	}
+	c.logFile.Close()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultStopTimeout = 10                     // stop 默认等待的秒数
+	finishPollInterval = 100 * time.Millisecond // 轮询容器状态的间隔
+	killGracePeriod    = 2 * time.Second        // kill 之后等待容器退出的时间
+)
+
+// signals 支持按名字指定的信号.
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// stop 优雅地停止容器
+// ./duoker stop [-t seconds] NAME...
+// 先发送 SIGTERM 超时后再发送 SIGKILL.
+func stop(args []string) error {
+	fs := cli.NewFlagSet("stop", "stop [-t seconds] NAME...")
+	timeout := fs.Int("t", defaultStopTimeout, "seconds to wait for stop before killing it")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fs.UsageError()
+	}
+	for _, name := range fs.Args() {
+		if err := stopContainer(name, time.Duration(*timeout)*time.Second); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// kill 向容器的 1 号进程发送信号
+// ./duoker kill [-s SIGNAL] NAME...
+func kill(args []string) error {
+	fs := cli.NewFlagSet("kill", "kill [-s SIGNAL] NAME...")
+	sigName := fs.String("s", "KILL", "signal to send to the container")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fs.UsageError()
+	}
+	sig, err := parseSignal(*sigName)
+	if err != nil {
+		return err
+	}
+	for _, name := range fs.Args() {
+		info, err := loadRunning(name)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Kill(info.Pid, sig); err != nil {
+			return fmt.Errorf("kill container %s fail %s", name, err)
+		}
+		// 正常情况下 supervisor 会负责清理
+		// supervisor 已经不在时 (例如 run 进程被强制杀掉) 由这里完成清理
+		if !container.Alive(info.ShimPid) {
+			if _, err := waitFinished(info, killGracePeriod, 128+int(sig)); err != nil {
+				return err
+			}
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// stopContainer 发送 SIGTERM 并等待容器退出 超时后发送 SIGKILL.
+func stopContainer(name string, timeout time.Duration) error {
+	info, err := container.Load(name)
+	if err != nil {
+		return err
+	}
+	// 已经退出的容器不需要再停止 只需要确认已经清理
+	if info.Status != container.StatusRunning {
+		if info.Cleaned() {
+			return nil
+		}
+		if starting(info) {
+			return fmt.Errorf("container %s is still starting, try again later", name)
+		}
+		return finish(info.Name, info.ExitCode)
+	}
+	if err := syscall.Kill(info.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("stop container %s fail %s", name, err)
+	}
+	if done, err := waitFinished(info, timeout, 128+int(syscall.SIGTERM)); err != nil || done {
+		return err
+	}
+	if err := syscall.Kill(info.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("kill container %s fail %s", name, err)
+	}
+	done, err := waitFinished(info, defaultStopTimeout*time.Second, 128+int(syscall.SIGKILL))
+	if err != nil {
+		return err
+	}
+	if !done {
+		return fmt.Errorf("container %s did not exit after SIGKILL", name)
+	}
+	return nil
+}
+
+// starting 判断容器是否还在启动中 此时 init 进程正在配置容器 不能清理它的资源
+// 启动它的进程已经不在时 (例如被强制杀掉) 容器不会再启动 可以清理.
+func starting(info *container.Info) bool {
+	return info.Status == container.StatusCreated && container.Alive(info.ShimPid)
+}
+
+// loadRunning 读取容器状态 并确认容器正在运行.
+func loadRunning(name string) (*container.Info, error) {
+	info, err := container.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != container.StatusRunning {
+		return nil, fmt.Errorf("container %s is not running", name)
+	}
+	return info, nil
+}
+
+// waitFinished 等待容器退出并完成清理 超时返回 false
+// supervisor 已经不在时由调用方执行清理 code 作为记录的退出码.
+func waitFinished(info *container.Info, timeout time.Duration, code int) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := container.Load(info.Name)
+		if err != nil {
+			return false, err
+		}
+		if current.Cleaned() {
+			return true, nil
+		}
+		if !container.Alive(info.Pid) && !container.Alive(info.ShimPid) {
+			return true, finish(info.Name, code)
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(finishPollInterval)
+	}
+}
+
+// parseSignal 解析信号 支持 KILL SIGKILL 9 等写法.
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal %s", s)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid signal %s", s)
+	}
+	return sig, nil
+}
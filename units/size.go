@@ -0,0 +1,61 @@
+// Package units 处理命令行中带单位的数值 例如 10m 64k 1g.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	KiB = 1024
+	MiB = 1024 * KiB
+	GiB = 1024 * MiB
+	TiB = 1024 * GiB
+)
+
+// sizeUnits 单位后缀到字节数的映射 统一按 1024 进制计算.
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": KiB, "kb": KiB, "kib": KiB,
+	"m": MiB, "mb": MiB, "mib": MiB,
+	"g": GiB, "gb": GiB, "gib": GiB,
+	"t": TiB, "tb": TiB, "tib": TiB,
+}
+
+// ParseSize 将 10m 1.5g 4096 等写法解析为字节数.
+func ParseSize(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(str)
+	}
+	num, unit := str[:i], strings.TrimSpace(str[i:])
+	multiplier, ok := sizeUnits[unit]
+	if num == "" || !ok {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// HumanSize 将字节数转为便于阅读的形式 例如 1.5GiB.
+func HumanSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
@@ -0,0 +1,42 @@
+package units
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"4096":  4096,
+		"64k":   64 * KiB,
+		"10m":   10 * MiB,
+		"10MB":  10 * MiB,
+		"1.5g":  GiB + GiB/2,
+		"2 GiB": 2 * GiB,
+	}
+	for in, want := range cases {
+		got, err := ParseSize(in)
+		if err != nil {
+			t.Fatalf("ParseSize(%q) err=%s", in, err)
+		}
+		if got != want {
+			t.Fatalf("ParseSize(%q)=%d want %d", in, got, want)
+		}
+	}
+	for _, in := range []string{"", "m", "10x", "-1m"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Fatalf("ParseSize(%q) should fail", in)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	cases := map[int64]string{
+		512:              "512B",
+		64 * KiB:         "64KiB",
+		GiB + GiB/2:      "1.5GiB",
+		10*MiB + 512*KiB: "10.5MiB",
+	}
+	for in, want := range cases {
+		if got := HumanSize(in); got != want {
+			t.Fatalf("HumanSize(%d)=%s want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package volume 管理 duoker 创建的命名卷
+// 每个卷位于 /workplace/duoker/volumes/<name> 结构如下
+//
+//	volume.json   卷的记录
+//	_data         卷的内容 挂载到容器中的目录
+package volume
+
+import (
+	"crypto/rand"
+	"duoker/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	volumeFile = "volume.json" // 卷目录下的记录
+	dataDir    = "_data"       // 卷目录下保存内容的目录
+	lockFile   = "lock"        // 创建和删除卷时使用的锁
+)
+
+// storagePath 卷的存储目录 测试时替换为临时目录.
+var storagePath = config.VolumeStoragePath
+
+// validName 卷名 和容器名一样不能包含 / 并且不能以 . 开头 这样不会和路径混淆.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Volume 一个命名卷.
+type Volume struct {
+	Name       string    `json:"name"`       // 卷名
+	Mountpoint string    `json:"mountpoint"` // 卷的内容在宿主机上的目录
+	Created    time.Time `json:"created"`    // 创建时间
+}
+
+// IsName 判断 -v 参数中冒号前的部分是卷名而不是宿主机路径.
+func IsName(s string) bool {
+	return validName.MatchString(s)
+}
+
+// Dir 卷的目录.
+func Dir(name string) string {
+	return filepath.Join(storagePath, name)
+}
+
+// Create 创建卷 name 为空时生成随机的卷名
+// 同名的卷已经存在时直接返回它 第二个返回值表示是否新创建.
+func Create(name string) (*Volume, bool, error) {
+	if name == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, false, fmt.Errorf("generate volume name fail err=%s", err)
+		}
+		name = hex.EncodeToString(b)
+	}
+	if !IsName(name) {
+		return nil, false, fmt.Errorf("invalid volume name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	unlock, err := lock()
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+	if v, err := Get(name); err == nil {
+		return v, false, nil
+	}
+	v := &Volume{
+		Name:       name,
+		Mountpoint: filepath.Join(Dir(name), dataDir),
+		Created:    time.Now(),
+	}
+	if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+		return nil, false, fmt.Errorf("mkdir volume %s fail err=%s", name, err)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, false, err
+	}
+	// 记录最后写入 有记录的卷才是完整的
+	if err := os.WriteFile(filepath.Join(Dir(name), volumeFile), data, 0644); err != nil {
+		return nil, false, fmt.Errorf("write volume %s fail err=%s", name, err)
+	}
+	return v, true, nil
+}
+
+// Get 读取卷的记录.
+func Get(name string) (*Volume, error) {
+	if !IsName(name) {
+		return nil, fmt.Errorf("invalid volume name %s", name)
+	}
+	data, err := os.ReadFile(filepath.Join(Dir(name), volumeFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such volume: %s", name)
+		}
+		return nil, fmt.Errorf("read volume %s fail err=%s", name, err)
+	}
+	v := &Volume{}
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("parse volume %s fail err=%s", name, err)
+	}
+	return v, nil
+}
+
+// List 列出所有的卷 按卷名排序.
+func List() ([]*Volume, error) {
+	entries, err := os.ReadDir(storagePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var volumes []*Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := Get(entry.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(a, b int) bool {
+		return volumes[a].Name < volumes[b].Name
+	})
+	return volumes, nil
+}
+
+// Check 在卷目录的锁内确认卷仍然存在 并且没有被删除后重新创建
+// run 在保存容器状态之后调用 如果创建卷之后卷被 volume rm 或 prune 删除了 这里会返回错误
+// 否则之后的 volume rm 和 prune 一定能看到这个容器.
+func Check(volumes []*Volume) error {
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for _, v := range volumes {
+		cur, err := Get(v.Name)
+		if err != nil || !cur.Created.Equal(v.Created) {
+			return fmt.Errorf("volume %s was removed while the container was being created", v.Name)
+		}
+	}
+	return nil
+}
+
+// Users 返回每个卷被哪些容器使用 卷名 -> 容器名列表
+// volume 包不依赖 container 包 由调用方从容器状态中读取 在卷目录的锁内调用.
+type Users func() (map[string][]string, error)
+
+// Remove 删除卷和它的内容 还有容器 (包括已经退出的) 使用的卷不能删除.
+func Remove(name string, users Users) error {
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := Get(name); err != nil {
+		return err
+	}
+	inUse, err := users()
+	if err != nil {
+		return err
+	}
+	if containers := inUse[name]; len(containers) > 0 {
+		return fmt.Errorf("volume %s is in use by container %s, remove the container first",
+			name, strings.Join(containers, ", "))
+	}
+	return remove(name)
+}
+
+// Prune 删除所有没有被容器使用的卷 返回删除的卷和回收的空间.
+func Prune(users Users) ([]string, int64, error) {
+	unlock, err := lock()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer unlock()
+	volumes, err := List()
+	if err != nil {
+		return nil, 0, err
+	}
+	inUse, err := users()
+	if err != nil {
+		return nil, 0, err
+	}
+	var removed []string
+	var reclaimed int64
+	for _, v := range volumes {
+		if len(inUse[v.Name]) > 0 {
+			continue
+		}
+		size := v.Size()
+		if err := remove(v.Name); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, v.Name)
+		reclaimed += size
+	}
+	return removed, reclaimed, nil
+}
+
+// remove 删除卷的记录和内容 调用方需要持有锁.
+func remove(name string) error {
+	// 先删除记录 删除内容中途失败时不会留下一个看起来完整的卷
+	if err := os.Remove(filepath.Join(Dir(name), volumeFile)); err != nil {
+		return fmt.Errorf("remove volume %s fail err=%s", name, err)
+	}
+	if err := os.RemoveAll(Dir(name)); err != nil {
+		return fmt.Errorf("remove volume %s fail err=%s", name, err)
+	}
+	return nil
+}
+
+// Size 卷中文件的大小之和.
+func (v *Volume) Size() int64 {
+	var size int64
+	filepath.Walk(v.Mountpoint, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// lock 对卷目录加文件锁 返回解锁函数.
+func lock() (func(), error) {
+	if err := os.MkdirAll(storagePath, 0700); err != nil {
+		return nil, fmt.Errorf("mkdir volume store fail err=%s", err)
+	}
+	f, err := os.OpenFile(filepath.Join(storagePath, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open volume store lock fail err=%s", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock volume store fail err=%s", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
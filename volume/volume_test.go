@@ -0,0 +1,142 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsName(t *testing.T) {
+	for _, name := range []string{"data", "my-vol_1", "a.b", "0cache"} {
+		if !IsName(name) {
+			t.Fatalf("IsName(%s) = false", name)
+		}
+	}
+	for _, name := range []string{"", ".", "..", ".hidden", "a/b", "-x", "a:b", "a b"} {
+		if IsName(name) {
+			t.Fatalf("IsName(%s) = true", name)
+		}
+	}
+}
+
+// useTempStorage 把卷的存储目录替换为临时目录.
+func useTempStorage(t *testing.T) {
+	old := storagePath
+	storagePath = t.TempDir()
+	t.Cleanup(func() { storagePath = old })
+}
+
+// noUsers 没有容器使用任何卷.
+func noUsers() (map[string][]string, error) {
+	return nil, nil
+}
+
+func TestCreateGetRemove(t *testing.T) {
+	useTempStorage(t)
+	v, created, err := Create("data")
+	if err != nil || !created {
+		t.Fatalf("Create(data) created=%v err=%v", created, err)
+	}
+	if v.Mountpoint != filepath.Join(storagePath, "data", dataDir) {
+		t.Fatalf("got mountpoint %s", v.Mountpoint)
+	}
+	if err := os.WriteFile(filepath.Join(v.Mountpoint, "f"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 同名的卷直接返回 不清空内容
+	if again, created, err := Create("data"); err != nil || created || again.Mountpoint != v.Mountpoint {
+		t.Fatalf("Create(data) again created=%v err=%v", created, err)
+	}
+	got, err := Get("data")
+	if err != nil || got.Name != "data" || got.Size() != 5 {
+		t.Fatalf("Get(data) = %+v err=%v", got, err)
+	}
+	anonymous, _, err := Create("")
+	if err != nil || !IsName(anonymous.Name) {
+		t.Fatalf("Create() = %+v err=%v", anonymous, err)
+	}
+	if volumes, err := List(); err != nil || len(volumes) != 2 {
+		t.Fatalf("List() = %v err=%v", volumes, err)
+	}
+	if _, _, err := Create("../x"); err == nil {
+		t.Fatal("Create(../x) succeeded")
+	}
+
+	if err := Remove("data", noUsers); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(Dir("data")); !os.IsNotExist(err) {
+		t.Fatalf("volume dir still exists err=%v", err)
+	}
+	if err := Remove("data", noUsers); err == nil || !strings.Contains(err.Error(), "no such volume") {
+		t.Fatalf("Remove(data) again err=%v", err)
+	}
+}
+
+func TestRemoveInUse(t *testing.T) {
+	useTempStorage(t)
+	if _, _, err := Create("data"); err != nil {
+		t.Fatal(err)
+	}
+	users := func() (map[string][]string, error) {
+		return map[string][]string{"data": {"c1", "c2"}}, nil
+	}
+	err := Remove("data", users)
+	if err == nil || !strings.Contains(err.Error(), "in use by container c1, c2") {
+		t.Fatalf("Remove(data) err=%v", err)
+	}
+	if _, err := Get("data"); err != nil {
+		t.Fatalf("volume removed while in use err=%v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	useTempStorage(t)
+	for _, name := range []string{"a", "b", "c"} {
+		v, _, err := Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(v.Mountpoint, "f"), []byte(name), 0644)
+	}
+	users := func() (map[string][]string, error) {
+		return map[string][]string{"b": {"c1"}}, nil
+	}
+	removed, reclaimed, err := Prune(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{"a", "c"}) || reclaimed != 2 {
+		t.Fatalf("Prune() = %v %d", removed, reclaimed)
+	}
+	volumes, err := List()
+	if err != nil || len(volumes) != 1 || volumes[0].Name != "b" {
+		t.Fatalf("List() = %v err=%v", volumes, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	useTempStorage(t)
+	v, _, err := Create("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Check([]*Volume{v}); err != nil {
+		t.Fatal(err)
+	}
+	// 删除后重新创建的同名卷不是原来的卷
+	if err := Remove("data", noUsers); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check([]*Volume{v}); err == nil {
+		t.Fatal("Check succeeded for a removed volume")
+	}
+	if _, _, err := Create("data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check([]*Volume{v}); err == nil {
+		t.Fatal("Check succeeded for a recreated volume")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"duoker/cli"
+	"duoker/container"
+	"duoker/units"
+	"duoker/volume"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// volumeSubcommands volume 的二级子命令.
+var volumeSubcommands = []string{"create", "ls", "inspect", "rm", "prune"}
+
+// volumeCommand 管理命名卷
+// ./duoker volume create|ls|inspect|rm|prune.
+func volumeCommand(args []string) error {
+	usage := cli.Usagef("volume %s", strings.Join(volumeSubcommands, "|"))
+	if len(args) < 1 {
+		return usage
+	}
+	switch args[0] {
+	case "-h", "--help":
+		fmt.Printf("Usage: duoker volume %s\n\nRun 'duoker volume COMMAND --help' for more information on a command.\n",
+			strings.Join(volumeSubcommands, "|"))
+		return nil
+	case "create":
+		return volumeCreate(args[1:])
+	case "ls":
+		return volumeList(args[1:])
+	case "inspect":
+		return volumeInspect(args[1:])
+	case "rm":
+		return volumeRemove(args[1:])
+	case "prune":
+		return volumePrune(args[1:])
+	default:
+		return usage
+	}
+}
+
+// volumeCreate 创建命名卷 省略卷名时生成随机的卷名
+// ./duoker volume create [NAME].
+func volumeCreate(args []string) error {
+	fs := cli.NewFlagSet("volume create", "volume create [NAME]")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fs.UsageError()
+	}
+	v, _, err := volume.Create(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(v.Name)
+	return nil
+}
+
+// volumeList 列出所有命名卷和使用它们的容器
+// ./duoker volume ls [-q].
+func volumeList(args []string) error {
+	fs := cli.NewFlagSet("volume ls", "volume ls [OPTIONS]")
+	quiet := fs.Bool("q", false, "only display volume names")
+	fs.Parse(args)
+
+	volumes, err := volume.List()
+	if err != nil {
+		return err
+	}
+	if *quiet {
+		for _, v := range volumes {
+			fmt.Println(v.Name)
+		}
+		return nil
+	}
+	users, err := volumeUsers()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "VOLUME NAME\tSIZE\tCONTAINERS\tCREATED")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Name, units.HumanSize(v.Size()),
+			strings.Join(users[v.Name], ","), v.Created.Format("2006-01-02 15:04:05"))
+	}
+	return w.Flush()
+}
+
+// volumeInspect 以 JSON 格式输出卷的详细信息
+// ./duoker volume inspect NAME...
+func volumeInspect(args []string) error {
+	fs := cli.NewFlagSet("volume inspect", "volume inspect NAME...")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fs.UsageError()
+	}
+	users, err := volumeUsers()
+	if err != nil {
+		return err
+	}
+	type volumeDetail struct {
+		*volume.Volume
+		Size       int64    `json:"size"`
+		Containers []string `json:"containers"`
+	}
+	details := []volumeDetail{}
+	for _, name := range fs.Args() {
+		v, err := volume.Get(name)
+		if err != nil {
+			return err
+		}
+		containers := users[v.Name]
+		if containers == nil {
+			containers = []string{}
+		}
+		details = append(details, volumeDetail{v, v.Size(), containers})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(details)
+}
+
+// volumeRemove 删除命名卷 还有容器 (包括已经退出的) 使用的卷不能删除
+// ./duoker volume rm NAME...
+func volumeRemove(args []string) error {
+	fs := cli.NewFlagSet("volume rm", "volume rm NAME...")
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return fs.UsageError()
+	}
+	for _, name := range fs.Args() {
+		if err := volume.Remove(name, volumeUsers); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// volumePrune 删除所有没有被容器使用的命名卷
+// ./duoker volume prune.
+func volumePrune(args []string) error {
+	fs := cli.NewFlagSet("volume prune", "volume prune")
+	fs.Parse(args)
+	removed, reclaimed, err := volume.Prune(volumeUsers)
+	for _, name := range removed {
+		fmt.Println(name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Total reclaimed space: %s\n", units.HumanSize(reclaimed))
+	return nil
+}
+
+// volumeUsers 返回每个命名卷被哪些容器使用 卷名 -> 容器名列表.
+func volumeUsers() (map[string][]string, error) {
+	infos, err := container.List()
+	if err != nil {
+		return nil, err
+	}
+	users := map[string][]string{}
+	for _, info := range infos {
+		for _, m := range info.Mounts {
+			if m.Name != "" {
+				users[m.Name] = append(users[m.Name], info.Name)
+			}
+		}
+	}
+	return users, nil
+}
@@ -0,0 +1,119 @@
+package workspace
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// device 容器 /dev 下创建的设备文件.
+type device struct {
+	name         string
+	major, minor uint32
+}
+
+// defaultDevices 和 docker 一样 容器中默认只有这些设备.
+var defaultDevices = []device{
+	{"null", 1, 3},
+	{"zero", 1, 5},
+	{"full", 1, 7},
+	{"random", 1, 8},
+	{"urandom", 1, 9},
+	{"tty", 5, 0},
+}
+
+// devSymlinks /dev 下的符号链接 链接名 -> 目标.
+var devSymlinks = [][2]string{
+	{"fd", "/proc/self/fd"},
+	{"stdin", "/proc/self/fd/0"},
+	{"stdout", "/proc/self/fd/1"},
+	{"stderr", "/proc/self/fd/2"},
+	{"ptmx", "pts/ptmx"},
+}
+
+// setupDev 在 pivot_root 之前为容器准备 /dev
+// 镜像中的 /dev 会被一个新的 tmpfs 盖住 其中只有默认的设备 容器看不到宿主机的磁盘等设备
+// /dev/pts 使用新的 devpts 实例 容器内分配的伪终端和宿主机上的互不可见.
+func setupDev(rootfs string) error {
+	dev, err := securePath(rootfs, "/dev")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return fmt.Errorf("mkdir /dev fail err=%s", err)
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return fmt.Errorf("mount tmpfs on /dev fail err=%s", err)
+	}
+	for _, d := range defaultDevices {
+		if err := createDevice(dev, d); err != nil {
+			return err
+		}
+	}
+
+	// 子目录和对应的文件系统
+	mounts := []struct {
+		dir, fstype string
+		flags       uintptr
+		data        string
+		mode        os.FileMode
+	}{
+		// gid=5 为 tty 组 和 docker 一样
+		{"pts", "devpts", syscall.MS_NOSUID | syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620,gid=5", 0755},
+		{"shm", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "mode=1777,size=65536k", 01777},
+		{"mqueue", "mqueue", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "", 0755},
+	}
+	for _, m := range mounts {
+		path := filepath.Join(dev, m.dir)
+		if err := os.Mkdir(path, m.mode); err != nil {
+			return fmt.Errorf("mkdir /dev/%s fail err=%s", m.dir, err)
+		}
+		if err := syscall.Mount(m.fstype, path, m.fstype, m.flags, m.data); err != nil {
+			return fmt.Errorf("mount %s on /dev/%s fail err=%s", m.fstype, m.dir, err)
+		}
+	}
+
+	for _, link := range devSymlinks {
+		if err := os.Symlink(link[1], filepath.Join(dev, link[0])); err != nil {
+			return fmt.Errorf("create /dev/%s fail err=%s", link[0], err)
+		}
+	}
+	return nil
+}
+
+// createDevice 创建设备文件 没有 mknod 的权限时 (例如在 user namespace 中) 改为 bind 挂载宿主机上的设备.
+func createDevice(dev string, d device) error {
+	path := filepath.Join(dev, d.name)
+	err := unix.Mknod(path, unix.S_IFCHR|0666, int(unix.Mkdev(d.major, d.minor)))
+	if err == nil {
+		// mknod 创建的权限会受 umask 影响
+		return os.Chmod(path, 0666)
+	}
+	if err != unix.EPERM {
+		return fmt.Errorf("mknod /dev/%s fail err=%s", d.name, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0666)
+	if err != nil {
+		return fmt.Errorf("create /dev/%s fail err=%s", d.name, err)
+	}
+	f.Close()
+	if err := syscall.Mount("/dev/"+d.name, path, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind /dev/%s fail err=%s", d.name, err)
+	}
+	return nil
+}
+
+// BindConsole 把容器的伪终端 bind 挂载到 /dev/console 在 pivot_root 之后调用.
+func BindConsole(slave string) error {
+	f, err := os.OpenFile("/dev/console", os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create /dev/console fail err=%s", err)
+	}
+	f.Close()
+	if err := syscall.Mount(slave, "/dev/console", "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind %s to /dev/console fail err=%s", slave, err)
+	}
+	return nil
+}
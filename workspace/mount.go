@@ -0,0 +1,339 @@
+package workspace
+
+import (
+	"duoker/units"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks 解析容器内路径时最多跟随的符号链接数 和内核的 MAXSYMLINKS 一致.
+const maxSymlinks = 40
+
+// propagations bind 挂载支持的传播类型.
+var propagations = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+}
+
+// tmpfsFlags tmpfs 挂载支持的挂载标志 值为 true 时设置对应的标志 false 时清除.
+var tmpfsFlags = map[string]struct {
+	set  bool
+	flag uintptr
+}{
+	"ro":     {true, syscall.MS_RDONLY},
+	"rw":     {false, syscall.MS_RDONLY},
+	"noexec": {true, syscall.MS_NOEXEC},
+	"exec":   {false, syscall.MS_NOEXEC},
+	"nosuid": {true, syscall.MS_NOSUID},
+	"suid":   {false, syscall.MS_NOSUID},
+	"nodev":  {true, syscall.MS_NODEV},
+	"dev":    {false, syscall.MS_NODEV},
+}
+
+// MountTypeTmpfs --tmpfs 指定的挂载 其他挂载都是 bind 挂载.
+const MountTypeTmpfs = "tmpfs"
+
+// Mount 挂载到容器中的宿主机目录或文件 即 run -v 指定的卷 以及 --tmpfs 指定的 tmpfs.
+type Mount struct {
+	Type        string `json:"type,omitempty"`        // 为空时是 bind 挂载 tmpfs 时没有 Source
+	Name        string `json:"name,omitempty"`        // 命名卷的卷名 宿主机路径为卷的目录
+	Source      string `json:"source"`                // 宿主机上的绝对路径
+	Destination string `json:"destination"`           // 容器内的绝对路径
+	ReadOnly    bool   `json:"readOnly,omitempty"`    // 只读挂载
+	Propagation string `json:"propagation,omitempty"` // 挂载传播类型 默认为 rprivate
+	Options     string `json:"options,omitempty"`     // tmpfs 的挂载参数
+}
+
+// ParseVolume 解析 -v 参数 hostpath|name:containerpath[:options]
+// options 以逗号分隔 可以是 ro rw 以及一个传播类型 private rprivate slave rslave shared rshared
+// 以 / 或者 . 开头的是宿主机路径 必须存在 返回时已经转换为绝对路径
+// 否则是命名卷的卷名 由调用方创建卷并填写 Source.
+func ParseVolume(spec string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Mount{}, fmt.Errorf("invalid volume %s, expect hostpath:containerpath[:options]", spec)
+	}
+	m := Mount{Destination: parts[1], Propagation: "rprivate"}
+	if filepath.IsAbs(parts[0]) || strings.HasPrefix(parts[0], ".") {
+		source, err := filepath.Abs(parts[0])
+		if err != nil {
+			return Mount{}, fmt.Errorf("resolve volume path %s fail err=%s", parts[0], err)
+		}
+		if _, err := os.Stat(source); err != nil {
+			return Mount{}, fmt.Errorf("volume %s: host path %s fail err=%s", spec, source, err)
+		}
+		m.Source = source
+	} else if parts[0] == "" || strings.Contains(parts[0], "/") {
+		return Mount{}, fmt.Errorf("invalid volume %s, host path %s must be absolute", spec, parts[0])
+	} else {
+		m.Name = parts[0]
+	}
+	if !filepath.IsAbs(m.Destination) {
+		return Mount{}, fmt.Errorf("invalid volume %s, container path %s must be absolute", spec, m.Destination)
+	}
+	m.Destination = filepath.Clean(m.Destination)
+	if m.Destination == "/" {
+		return Mount{}, fmt.Errorf("invalid volume %s, cannot mount over container root", spec)
+	}
+	if len(parts) == 3 {
+		var mode, propagation bool
+		for _, opt := range strings.Split(parts[2], ",") {
+			switch {
+			case opt == "ro" || opt == "rw":
+				if mode {
+					return Mount{}, fmt.Errorf("invalid volume %s, duplicate ro/rw option", spec)
+				}
+				mode, m.ReadOnly = true, opt == "ro"
+			case propagations[opt] != 0:
+				if propagation {
+					return Mount{}, fmt.Errorf("invalid volume %s, duplicate propagation option", spec)
+				}
+				propagation, m.Propagation = true, opt
+			default:
+				return Mount{}, fmt.Errorf("invalid volume %s, unknown option %s", spec, opt)
+			}
+		}
+	}
+	return m, nil
+}
+
+// ParseTmpfs 解析 --tmpfs 参数 /path[:options]
+// options 以逗号分隔 支持 size mode uid gid nr_inodes 以及 ro rw exec noexec suid nosuid dev nodev
+// size 可以带单位 例如 size=64m 默认为 noexec,nosuid,nodev.
+func ParseTmpfs(spec string) (Mount, error) {
+	dest, options, _ := strings.Cut(spec, ":")
+	if !filepath.IsAbs(dest) {
+		return Mount{}, fmt.Errorf("invalid tmpfs %s, container path %s must be absolute", spec, dest)
+	}
+	dest = filepath.Clean(dest)
+	if dest == "/" {
+		return Mount{}, fmt.Errorf("invalid tmpfs %s, cannot mount over container root", spec)
+	}
+	m := Mount{Type: MountTypeTmpfs, Destination: dest}
+	var opts []string
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(opt, "=")
+		if _, ok := tmpfsFlags[opt]; ok {
+			opts = append(opts, opt)
+			continue
+		}
+		if !hasValue || value == "" {
+			return Mount{}, fmt.Errorf("invalid tmpfs %s, unknown option %s", spec, opt)
+		}
+		switch key {
+		case "size":
+			if !strings.HasSuffix(value, "%") {
+				size, err := units.ParseSize(value)
+				if err != nil {
+					return Mount{}, fmt.Errorf("invalid tmpfs %s, %s", spec, err)
+				}
+				value = strconv.FormatInt(size, 10)
+			}
+		case "mode":
+			if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+				return Mount{}, fmt.Errorf("invalid tmpfs %s, mode %s must be octal", spec, value)
+			}
+		case "uid", "gid", "nr_inodes":
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return Mount{}, fmt.Errorf("invalid tmpfs %s, %s %s must be a number", spec, key, value)
+			}
+		default:
+			return Mount{}, fmt.Errorf("invalid tmpfs %s, unknown option %s", spec, key)
+		}
+		opts = append(opts, key+"="+value)
+	}
+	m.Options = strings.Join(opts, ",")
+	m.ReadOnly = tmpfsMountFlags(m.Options)&syscall.MS_RDONLY != 0
+	return m, nil
+}
+
+// tmpfsMountFlags 根据 tmpfs 的参数计算挂载标志 后出现的参数覆盖前面的.
+func tmpfsMountFlags(options string) uintptr {
+	flags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+	for _, opt := range strings.Split(options, ",") {
+		if f, ok := tmpfsFlags[opt]; ok {
+			if f.set {
+				flags |= f.flag
+			} else {
+				flags &^= f.flag
+			}
+		}
+	}
+	return flags
+}
+
+// tmpfsData tmpfs 参数中交给文件系统的部分 即去掉挂载标志后的参数.
+func tmpfsData(options string) string {
+	var data []string
+	for _, opt := range strings.Split(options, ",") {
+		if _, ok := tmpfsFlags[opt]; !ok && opt != "" {
+			data = append(data, opt)
+		}
+	}
+	return strings.Join(data, ",")
+}
+
+// rootPropagation 挂载容器根文件系统之前 当前挂载命名空间中所有挂载点使用的传播类型
+// 默认为 private 宿主机和容器之间互不影响
+// 有卷需要接收宿主机上的挂载事件时使用 slave 宿主机上新的挂载仍能传播进来 容器内的挂载不会传播出去.
+func rootPropagation(mounts []Mount) uintptr {
+	for _, m := range mounts {
+		if propagations[m.Propagation]&(syscall.MS_SLAVE|syscall.MS_SHARED) != 0 {
+			return syscall.MS_SLAVE | syscall.MS_REC
+		}
+	}
+	return syscall.MS_PRIVATE | syscall.MS_REC
+}
+
+// mountVolumes 在 pivot_root 之前把卷和 tmpfs 挂载到容器根目录 rootfs 下
+// 按容器内路径的层级从浅到深挂载 /data 上的 tmpfs 不会盖住 /data/src 上的卷
+// 容器内的挂载点不存在时在读写层中创建 宿主机路径是文件时创建空文件作为挂载点
+// 命名卷为空时先把镜像中挂载点下原有的内容复制到卷中.
+func mountVolumes(rootfs string, mounts []Mount) error {
+	sorted := append([]Mount(nil), mounts...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return strings.Count(sorted[a].Destination, "/") < strings.Count(sorted[b].Destination, "/")
+	})
+	for _, m := range sorted {
+		target, err := securePath(rootfs, m.Destination)
+		if err != nil {
+			return err
+		}
+		if m.Type == MountTypeTmpfs {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("mkdir mount point %s fail err=%s", target, err)
+			}
+			if err := syscall.Mount("tmpfs", target, "tmpfs", tmpfsMountFlags(m.Options), tmpfsData(m.Options)); err != nil {
+				return fmt.Errorf("mount tmpfs on %s fail err=%s", m.Destination, err)
+			}
+			continue
+		}
+		if m.Name != "" {
+			if err := copyUp(target, m.Source); err != nil {
+				return fmt.Errorf("copy %s into volume %s fail err=%s", m.Destination, m.Name, err)
+			}
+		}
+		if err := createMountPoint(m.Source, target); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.Source, target, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount %s to %s fail err=%s", m.Source, m.Destination, err)
+		}
+		if m.ReadOnly {
+			// bind 挂载时会忽略 MS_RDONLY 需要再 remount 一次
+			flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+			if err := syscall.Mount("", target, "", flags, ""); err != nil {
+				return fmt.Errorf("remount %s read-only fail err=%s", m.Destination, err)
+			}
+		}
+		if err := syscall.Mount("", target, "", propagations[m.Propagation], ""); err != nil {
+			return fmt.Errorf("set %s propagation of %s fail err=%s", m.Propagation, m.Destination, err)
+		}
+	}
+	return nil
+}
+
+// copyUp 卷是空目录并且挂载点是非空目录时 把挂载点下的内容连同属主和权限复制到卷中
+// 这样第一次使用卷时容器看到的仍然是镜像中原有的内容.
+func copyUp(target, volume string) error {
+	stat, err := os.Lstat(target)
+	if err != nil || !stat.IsDir() {
+		return nil
+	}
+	if empty, err := isEmptyDir(volume); err != nil || !empty {
+		return err
+	}
+	if empty, err := isEmptyDir(target); err != nil || empty {
+		return err
+	}
+	if out, err := exec.Command("cp", "-a", target+"/.", volume).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// isEmptyDir 判断目录是否为空.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(1)
+	if len(names) == 0 {
+		return true, nil
+	}
+	return false, err
+}
+
+// createMountPoint 创建和宿主机路径类型相同的挂载点.
+func createMountPoint(source, target string) error {
+	stat, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("stat volume %s fail err=%s", source, err)
+	}
+	if stat.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("mkdir mount point %s fail err=%s", target, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("mkdir mount point %s fail err=%s", filepath.Dir(target), err)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("create mount point %s fail err=%s", target, err)
+	}
+	return f.Close()
+}
+
+// securePath 把容器内的路径解析为宿主机上 rootfs 下的路径
+// 路径中的符号链接按容器的根目录解析 镜像中指向 /etc 的链接不会落到宿主机的 /etc 上
+// 不存在的部分原样拼接 由调用方创建.
+func securePath(rootfs, path string) (string, error) {
+	var resolved string // 已经解析的部分 相对于 rootfs
+	rest := strings.Split(filepath.Clean("/"+path), "/")
+	for links := 0; len(rest) > 0; {
+		part := rest[0]
+		rest = rest[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir("/" + resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		stat, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil || stat.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in container path %s", path)
+		}
+		dest, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", fmt.Errorf("readlink %s fail err=%s", next, err)
+		}
+		if filepath.IsAbs(dest) {
+			resolved = ""
+		}
+		rest = append(strings.Split(dest, "/"), rest...)
+	}
+	return filepath.Join(rootfs, resolved), nil
+}
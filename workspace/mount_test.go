@@ -0,0 +1,160 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	host := t.TempDir()
+	cases := []struct {
+		spec string
+		want Mount
+	}{
+		{host + ":/data", Mount{Source: host, Destination: "/data", Propagation: "rprivate"}},
+		{host + ":/data/../src/:ro", Mount{Source: host, Destination: "/src", ReadOnly: true, Propagation: "rprivate"}},
+		{host + ":/data:rslave,ro", Mount{Source: host, Destination: "/data", ReadOnly: true, Propagation: "rslave"}},
+		{host + ":/data:rw,shared", Mount{Source: host, Destination: "/data", Propagation: "shared"}},
+		{"cache:/root/.cache:ro", Mount{Name: "cache", Destination: "/root/.cache", ReadOnly: true, Propagation: "rprivate"}},
+	}
+	for _, c := range cases {
+		got, err := ParseVolume(c.spec)
+		if err != nil {
+			t.Fatalf("ParseVolume(%s) err=%s", c.spec, err)
+		}
+		if got != c.want {
+			t.Fatalf("ParseVolume(%s) = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+
+	for _, spec := range []string{
+		host,
+		host + ":data",
+		host + ":/",
+		host + ":/data:ro,rw",
+		host + ":/data:rslave,private",
+		host + ":/data:exec",
+		"data/x:/data",
+		":/data",
+		filepath.Join(host, "missing") + ":/data",
+		host + ":/data:ro:x",
+	} {
+		if _, err := ParseVolume(spec); err == nil {
+			t.Fatalf("ParseVolume(%s) expect error", spec)
+		}
+	}
+}
+
+func TestSecurePath(t *testing.T) {
+	rootfs := t.TempDir()
+	os.MkdirAll(filepath.Join(rootfs, "usr/lib"), 0755)
+	os.Symlink("/usr/lib", filepath.Join(rootfs, "lib"))
+	os.Symlink("../../etc", filepath.Join(rootfs, "usr/etc"))
+	os.Symlink("/", filepath.Join(rootfs, "escape"))
+	os.Symlink("loop", filepath.Join(rootfs, "loop"))
+
+	cases := map[string]string{
+		"/data":              "/data",
+		"/lib/x":             "/usr/lib/x",
+		"/usr/etc/hosts":     "/etc/hosts",
+		"/escape/etc/passwd": "/etc/passwd",
+		"/../../etc":         "/etc",
+		"/usr/lib/../../tmp": "/tmp",
+	}
+	for path, want := range cases {
+		got, err := securePath(rootfs, path)
+		if err != nil {
+			t.Fatalf("securePath(%s) err=%s", path, err)
+		}
+		if got != filepath.Join(rootfs, want) {
+			t.Fatalf("securePath(%s) = %s, want %s", path, got, filepath.Join(rootfs, want))
+		}
+	}
+	if _, err := securePath(rootfs, "/loop/x"); err == nil {
+		t.Fatal("expect error for symlink loop")
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	m, err := ParseTmpfs("/run/../tmp:size=64m,mode=1777,exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != MountTypeTmpfs || m.Destination != "/tmp" || m.Options != "size=67108864,mode=1777,exec" || m.ReadOnly {
+		t.Fatalf("ParseTmpfs = %+v", m)
+	}
+	if flags := tmpfsMountFlags(m.Options); flags != syscall.MS_NOSUID|syscall.MS_NODEV {
+		t.Fatalf("flags = %#x", flags)
+	}
+	if data := tmpfsData(m.Options); data != "size=67108864,mode=1777" {
+		t.Fatalf("data = %s", data)
+	}
+
+	m, err = ParseTmpfs("/cache:ro,size=50%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.ReadOnly || tmpfsData(m.Options) != "size=50%" {
+		t.Fatalf("ParseTmpfs = %+v", m)
+	}
+	if m, err = ParseTmpfs("/scratch"); err != nil || m.Options != "" {
+		t.Fatalf("ParseTmpfs = %+v err=%v", m, err)
+	}
+
+	for _, spec := range []string{"tmp", "/", "/tmp:size=big", "/tmp:mode=999", "/tmp:uid=root", "/tmp:huge=always", "/tmp:size"} {
+		if _, err := ParseTmpfs(spec); err == nil {
+			t.Fatalf("ParseTmpfs(%s) expect error", spec)
+		}
+	}
+}
+
+func TestCopyUp(t *testing.T) {
+	target, volume := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(target, "sub"), 0700)
+	os.WriteFile(filepath.Join(target, "sub/f"), []byte("image"), 0600)
+	os.Chown(filepath.Join(target, "sub/f"), 1000, 1000)
+	if err := copyUp(target, volume); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(volume, "sub/f"))
+	if err != nil || string(data) != "image" {
+		t.Fatalf("copied file = %q err=%v", data, err)
+	}
+	stat, err := os.Stat(filepath.Join(volume, "sub"))
+	if err != nil || stat.Mode().Perm() != 0700 {
+		t.Fatalf("copied dir mode = %v err=%v", stat.Mode(), err)
+	}
+	if os.Getuid() == 0 {
+		stat, _ = os.Stat(filepath.Join(volume, "sub/f"))
+		if st := stat.Sys().(*syscall.Stat_t); st.Uid != 1000 || st.Gid != 1000 {
+			t.Fatalf("copied file owner = %d:%d", st.Uid, st.Gid)
+		}
+	}
+
+	// 卷不为空时不复制 不覆盖卷中已有的内容
+	os.WriteFile(filepath.Join(target, "new"), []byte("image"), 0644)
+	os.WriteFile(filepath.Join(volume, "sub/f"), []byte("volume"), 0600)
+	if err := copyUp(target, volume); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(volume, "new")); !os.IsNotExist(err) {
+		t.Fatalf("copied into non-empty volume err=%v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(volume, "sub/f")); string(data) != "volume" {
+		t.Fatalf("volume content overwritten: %q", data)
+	}
+
+	// 挂载点不存在或者不是目录时什么也不做
+	empty := t.TempDir()
+	if err := copyUp(filepath.Join(target, "missing"), empty); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyUp(filepath.Join(target, "new"), empty); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := isEmptyDir(empty); err != nil || !ok {
+		t.Fatalf("volume not empty err=%v", err)
+	}
+}
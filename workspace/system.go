@@ -0,0 +1,178 @@
+package workspace
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 以下函数在 pivot_root 并挂载 /proc 之后由 init 调用 路径都是容器内的路径.
+
+// cgroupMountPath 容器内 cgroup 文件系统的挂载点.
+const cgroupMountPath = "/sys/fs/cgroup"
+
+// DefaultMaskedPaths 和 docker 一样默认屏蔽的路径 文件用 /dev/null 盖住 目录用只读的空 tmpfs 盖住.
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths 和 docker 一样默认只读的路径.
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// MountSysfs 挂载只读的 /sys 以及 /sys/fs/cgroup
+// 容器已经有自己的 cgroup 命名空间 cgroup 文件系统的根目录就是容器自己的 cgroup.
+func MountSysfs() error {
+	if err := os.MkdirAll("/sys", 0755); err != nil {
+		return fmt.Errorf("mkdir /sys fail err=%s", err)
+	}
+	flags := uintptr(syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", flags, ""); err != nil {
+		return fmt.Errorf("mount sysfs fail err=%s", err)
+	}
+	return mountCgroups(flags)
+}
+
+// mountCgroups 按 /proc/self/cgroup 中的层级挂载 cgroup 文件系统
+// 只有 cgroup v2 时直接挂载在 /sys/fs/cgroup
+// cgroup v1 时和宿主机一样 在 tmpfs 上为每个层级挂载一个目录 例如 /sys/fs/cgroup/memory
+// 混合模式下的 cgroup v2 层级挂载在 /sys/fs/cgroup/unified.
+func mountCgroups(flags uintptr) error {
+	hierarchies, err := readCgroupHierarchies("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	if len(hierarchies) == 1 && hierarchies[0] == "" {
+		if err := syscall.Mount("cgroup2", cgroupMountPath, "cgroup2", flags, ""); err != nil {
+			return fmt.Errorf("mount cgroup2 fail err=%s", err)
+		}
+		return nil
+	}
+	if err := syscall.Mount("tmpfs", cgroupMountPath, "tmpfs", flags&^syscall.MS_RDONLY, "mode=755"); err != nil {
+		return fmt.Errorf("mount tmpfs on %s fail err=%s", cgroupMountPath, err)
+	}
+	for _, controllers := range hierarchies {
+		dir, fstype, data := cgroupMount(controllers)
+		path := filepath.Join(cgroupMountPath, dir)
+		if err := os.Mkdir(path, 0755); err != nil {
+			return fmt.Errorf("mkdir %s fail err=%s", path, err)
+		}
+		if err := syscall.Mount("cgroup", path, fstype, flags, data); err != nil {
+			return fmt.Errorf("mount cgroup %s fail err=%s", dir, err)
+		}
+		// 和宿主机一样 cpu,cpuacct 这样合并的层级为每个控制器建一个链接
+		if names := strings.Split(dir, ","); len(names) > 1 {
+			for _, name := range names {
+				if err := os.Symlink(dir, filepath.Join(cgroupMountPath, name)); err != nil {
+					return fmt.Errorf("create cgroup link %s fail err=%s", name, err)
+				}
+			}
+		}
+	}
+	if err := syscall.Mount("", cgroupMountPath, "", flags|syscall.MS_REMOUNT, "mode=755"); err != nil {
+		return fmt.Errorf("remount %s read-only fail err=%s", cgroupMountPath, err)
+	}
+	return nil
+}
+
+// cgroupMount 根据层级的控制器计算挂载的目录名 文件系统类型和挂载参数
+// controllers 为空时是 cgroup v2 命名层级 name=systemd 挂载在 systemd 目录下.
+func cgroupMount(controllers string) (dir, fstype, data string) {
+	switch {
+	case controllers == "":
+		return "unified", "cgroup2", ""
+	case strings.HasPrefix(controllers, "name="):
+		return strings.TrimPrefix(controllers, "name="), "cgroup", "none," + controllers
+	default:
+		return controllers, "cgroup", controllers
+	}
+}
+
+// readCgroupHierarchies 读取 /proc/self/cgroup 中每个层级的控制器 每行的格式为 id:controllers:path.
+func readCgroupHierarchies(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s fail err=%s", path, err)
+	}
+	defer f.Close()
+	var hierarchies []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		hierarchies = append(hierarchies, fields[1])
+	}
+	return hierarchies, scanner.Err()
+}
+
+// MaskPaths 屏蔽容器内的路径 不存在的路径直接跳过.
+func MaskPaths(paths []string) error {
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("stat masked path %s fail err=%s", path, err)
+		}
+		if stat.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", path, "bind", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("mask %s fail err=%s", path, err)
+		}
+	}
+	return nil
+}
+
+// ReadonlyPaths 把容器内的路径 bind 到自身后重新挂载为只读 不存在的路径直接跳过.
+func ReadonlyPaths(paths []string) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("stat readonly path %s fail err=%s", path, err)
+		}
+		if err := syscall.Mount(path, path, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind readonly path %s fail err=%s", path, err)
+		}
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		if err := syscall.Mount("", path, "", flags, ""); err != nil {
+			return fmt.Errorf("remount %s read-only fail err=%s", path, err)
+		}
+	}
+	return nil
+}
+
+// ReadonlyRootfs 把根目录的 bind 挂载改为只读 overlay 仍然有读写层
+// 卷 tmpfs 和 /dev 等是单独的挂载点 不受影响 所以在所有挂载完成之后最后调用.
+func ReadonlyRootfs() error {
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	if err := syscall.Mount("", "/", "", flags, ""); err != nil {
+		return fmt.Errorf("remount rootfs read-only fail err=%s", err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCgroupMount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cgroup")
+	content := "9:name=systemd:/\n4:memory:/\n2:cpu,cpuacct:/\n0::/\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hierarchies, err := readCgroupHierarchies(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"name=systemd", "memory", "cpu,cpuacct", ""}; !reflect.DeepEqual(hierarchies, want) {
+		t.Fatalf("hierarchies = %q, want %q", hierarchies, want)
+	}
+
+	cases := map[string][3]string{
+		"name=systemd": {"systemd", "cgroup", "none,name=systemd"},
+		"memory":       {"memory", "cgroup", "memory"},
+		"cpu,cpuacct":  {"cpu,cpuacct", "cgroup", "cpu,cpuacct"},
+		"":             {"unified", "cgroup2", ""},
+	}
+	for controllers, want := range cases {
+		dir, fstype, data := cgroupMount(controllers)
+		if got := [3]string{dir, fstype, data}; got != want {
+			t.Fatalf("cgroupMount(%q) = %q, want %q", controllers, got, want)
+		}
+	}
+}
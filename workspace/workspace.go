@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"syscall"
 )
 
@@ -13,8 +15,6 @@ const (
 	mntPath        = "/workplace/duoker/rootfs/mnt"
 	workLayerPath  = "/workplace/duoker/rootfs/work"
 	writeLayerPath = "/workplace/duoker/rootfs/wlayer"
-	// 我们的 base 文件目录就在编译好的 duoker 的目录下 所以这个用相对目录
-	imagePath = "ubuntu-base-22.04-base-amd64"
 	// put_old 为 new_root 的子文件夹 所以也用相对目录
 	mntOldPath = ".old"
 )
@@ -34,6 +34,11 @@ func writeLayer(containerName string) string {
 	return fmt.Sprintf("%s/%s", writeLayerPath, containerName)
 }
 
+// UpperDir 容器 overlay 的读写层 保留到容器被删除.
+func UpperDir(containerName string) string {
+	return writeLayer(containerName)
+}
+
 // mntOldLayer put_old 目录
 // 用于 pivot_root.
 func mntOldLayer(containerName string) string {
@@ -42,11 +47,24 @@ func mntOldLayer(containerName string) string {
 
 // SetMountNamespace 为容器设置挂载命名空间.
 // 1. 创建 overlay 联合文件系统
-//		1.1 配置只读层  也就是容器内的根文件系统
+//		1.1 配置只读层  也就是镜像的根文件系统 lowerdirs 需要是绝对路径 排在前面的在上层
 //		1.2 配置 work 空间 容器的工作目录
 //		1.3 配置 write 作为容器的读写层
 //		1.4 进行挂载
-func SetMountNamespace(containerName string) error {
+//
+// mounts 为 run -v 指定的卷和 --tmpfs 指定的 tmpfs 在 pivot_root 之前挂载到容器的根目录下.
+func SetMountNamespace(containerName string, lowerdirs []string, mounts []Mount) error {
+	if len(lowerdirs) == 0 {
+		return fmt.Errorf("no image layer for container %s", containerName)
+	}
+
+	// 1. 抽离上一版本 main.go 切换根目录的代码放在这里
+	// 	  systemd 启动默认是 share 模式 这样就不能隔离挂载可见性 所以在挂载 overlay 之前把当前根目录下所有目录设为 private 模式
+	//    有卷需要 slave 或 shared 传播时设为 slave 模式
+	if err := syscall.Mount("", "/", "", rootPropagation(mounts), ""); err != nil {
+		return fmt.Errorf("reclare rootfs private fail err=%s", err)
+	}
+
 	// 配置挂载目录
 	if err := os.Mkdir(mntLayer(containerName), 0700); err != nil {
 		return fmt.Errorf("mkdir mntlayer fail err=%s", err)
@@ -58,23 +76,25 @@ func SetMountNamespace(containerName string) error {
 	}
 
 	// 配置读写层目录
-	if err := os.Mkdir(writeLayer(containerName), 0700); err != nil {
+	// overlay 根目录的权限取自读写层 需要和普通的根目录一样是 0755 否则非 root 用户无法访问容器内的文件
+	if err := os.Mkdir(writeLayer(containerName), 0755); err != nil {
 		return fmt.Errorf("mkdir write layer fail err=%s", err)
 	}
 
-	// 1. 目录创建好后 进行 overlay 的挂载
-	// 	  这里会把我们的 Ubuntu base 目录挂载到 mntlayer 所在的文件夹下
-	if err := syscall.Mount("overlay", mntLayer(containerName), "overlay", 0,
-		fmt.Sprintf("upperdir=%s,lowerdir=%s,workdir=%s",
-			writeLayer(containerName), imagePath, workerLayer(containerName)),
-	); err != nil {
-		return fmt.Errorf("mount overlay fail err=%s", err)
+	// 2. 目录创建好后 进行 overlay 的挂载
+	// 	  这里会把镜像的根文件系统挂载到 mntlayer 所在的文件夹下
+	opts, dir, err := overlayOptions(lowerdirs, writeLayer(containerName), workerLayer(containerName))
+	if err != nil {
+		return err
 	}
-
-	// 2. 抽离上一版本 main.go 切换根目录的代码放在这里
-	// 	  systemd 启动默认是 share 模式 这样就不能隔离挂载可见性 所以当前根目录下所有目录设为 private 模式
-	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("reclare rootfs private fail err=%s", err)
+	if dir != "" {
+		// 层数太多时使用相对路径 需要先切换到各层所在的目录 pivot_root 之后会再切换到 /
+		if err := os.Chdir(dir); err != nil {
+			return fmt.Errorf("chdir to layer dir fail err=%s", err)
+		}
+	}
+	if err := syscall.Mount("overlay", mntLayer(containerName), "overlay", 0, opts); err != nil {
+		return fmt.Errorf("mount overlay fail err=%s", err)
 	}
 
 	// 3. 挂载新的根目录 也就是我们之前创建的 mnt
@@ -86,20 +106,116 @@ func SetMountNamespace(containerName string) error {
 	); err != nil {
 		return fmt.Errorf("mount rootfs in new mnt space fail err=%s", err)
 	}
+	// 4. 在镜像的 /dev 上挂载新的 tmpfs 只放入默认的设备
+	//    需要在卷之前 -v 可以把宿主机上的设备挂载到 /dev 下
+	if err := setupDev(mntLayer(containerName)); err != nil {
+		return err
+	}
+	// 5. 把卷挂载到新的根目录下 pivot_root 之后宿主机上的路径就访问不到了
+	if err := mountVolumes(mntLayer(containerName), mounts); err != nil {
+		return err
+	}
 
-	// 4. 配置 pivot_root 的 put_old 目录
-	if err := os.Mkdir(mntOldLayer(containerName), 0700); err != nil {
+	// 6. 配置 pivot_root 的 put_old 目录
+	if err := os.Mkdir(mntOldLayer(containerName), 0700); err != nil && !os.IsExist(err) {
 		return fmt.Errorf("mkdir .old for pivot_root fail err=%s", err)
 	}
 
-	// 5. 执行 pivot_root
+	// 7. 执行 pivot_root
 	if err := syscall.PivotRoot(mntLayer(containerName), mntOldLayer(containerName)); err != nil {
 		return fmt.Errorf("pivot root  fail err=%s", err)
 	}
 
+	// 8. 卸载原来的根目录 否则容器内可以通过 /.old 访问宿主机的文件
+	//    目录本身建在读写层中 也一并删除 不会被 commit 进镜像
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("chdir to new root fail err=%s", err)
+	}
+	oldRoot := "/" + mntOldPath
+	if err := syscall.Unmount(oldRoot, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root fail err=%s", err)
+	}
+	if err := os.Remove(oldRoot); err != nil {
+		return fmt.Errorf("remove old root dir fail err=%s", err)
+	}
+
 	return nil
 }
 
+// overlayOptions 生成 overlay 的挂载参数 upperdir 为空时生成只读挂载的参数
+// 内核只接受一页以内的挂载参数 层数多时 lowerdir 会超过这个长度
+// 这时把和最底层位于同一个目录下的各层 (即镜像仓库中的短链接) 改用相对路径 返回的 dir 为挂载前需要切换到的目录.
+func overlayOptions(lowerdirs []string, upperdir, workdir string) (opts, dir string, err error) {
+	format := func(lower []string) string {
+		if upperdir == "" {
+			return "lowerdir=" + strings.Join(lower, ":")
+		}
+		return fmt.Sprintf("upperdir=%s,lowerdir=%s,workdir=%s", upperdir, strings.Join(lower, ":"), workdir)
+	}
+	limit := os.Getpagesize() - 1
+	opts = format(lowerdirs)
+	if len(opts) <= limit {
+		return opts, "", nil
+	}
+	dir = filepath.Dir(lowerdirs[len(lowerdirs)-1])
+	relative := make([]string, 0, len(lowerdirs))
+	for _, lower := range lowerdirs {
+		if filepath.Dir(lower) == dir {
+			lower = filepath.Base(lower)
+		}
+		relative = append(relative, lower)
+	}
+	opts = format(relative)
+	if len(opts) > limit {
+		return "", "", fmt.Errorf("too many image layers (%d) for overlay mount options", len(lowerdirs))
+	}
+	return opts, dir, nil
+}
+
+// MountSnapshot 把容器的读写层和镜像的各层只读地挂载到一个临时目录 得到容器文件系统合并后的视图
+// 只能用于已经退出的容器 overlay 的只读层在挂载期间被修改是未定义行为 运行中的容器会修改自己的读写层
+// 返回挂载的目录和卸载它的函数.
+func MountSnapshot(containerName string, lowerdirs []string) (string, func(), error) {
+	if err := os.MkdirAll(mntPath, 0700); err != nil {
+		return "", nil, fmt.Errorf("mkdir mnt path fail err=%s", err)
+	}
+	dir, err := os.MkdirTemp(mntPath, containerName+"-snapshot-")
+	if err != nil {
+		return "", nil, fmt.Errorf("mkdir snapshot dir fail err=%s", err)
+	}
+	opts, chdir, err := overlayOptions(append([]string{writeLayer(containerName)}, lowerdirs...), "", "")
+	if err != nil {
+		os.Remove(dir)
+		return "", nil, err
+	}
+	if chdir != "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			os.Remove(dir)
+			return "", nil, err
+		}
+		if err := os.Chdir(chdir); err != nil {
+			os.Remove(dir)
+			return "", nil, fmt.Errorf("chdir to layer dir fail err=%s", err)
+		}
+		defer os.Chdir(cwd)
+	}
+	if err := syscall.Mount("overlay", dir, "overlay", syscall.MS_RDONLY, opts); err != nil {
+		os.Remove(dir)
+		return "", nil, fmt.Errorf("mount snapshot fail err=%s", err)
+	}
+	return dir, func() {
+		syscall.Unmount(dir, syscall.MNT_DETACH)
+		os.Remove(dir)
+	}, nil
+}
+
+// UnmountRootfs 容器退出后卸载 overlay 挂载点
+// 读写层和相关目录会保留下来 直到容器被 rm 删除.
+func UnmountRootfs(containerName string) error {
+	return unmountPath(mntLayer(containerName))
+}
+
 // DelMntNamespace 清理 overlay 文件系统和涉及到的的文件夹.
 func DelMntNamespace(containerName string) error {
 	if err := unmountAndDelPath(mntLayer(containerName)); err != nil {
@@ -116,13 +232,39 @@ func DelMntNamespace(containerName string) error {
 
 // unmountAndDelPath 在容器运行结束后 卸载挂载点并删除对应的目录.
 func unmountAndDelPath(path string) error {
-	_, err := exec.Command("umount", path).CombinedOutput()
-	if err != nil {
-		// return fmt.Errorf("umount fail path=%s err=%s", path, err)
-		fmt.Errorf("umount fail path=%s err=%s", path, err)
+	if err := unmountPath(path); err != nil {
+		return err
 	}
 	if err := os.RemoveAll(path); err != nil {
 		return fmt.Errorf("remove dir fail path=%s err=%s", path, err)
 	}
 	return nil
 }
+
+// unmountPath 卸载挂载点 不是挂载点时什么都不做.
+func unmountPath(path string) error {
+	mounted, err := isMountPoint(path)
+	if err != nil || !mounted {
+		return err
+	}
+	if out, err := exec.Command("umount", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("umount fail path=%s err=%s %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// isMountPoint 通过 /proc/self/mountinfo 判断路径是否是挂载点
+// 每行的第 5 个字段是挂载点.
+func isMountPoint(path string) (bool, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false, fmt.Errorf("read mountinfo fail err=%s", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,49 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestOverlayOptions(t *testing.T) {
+	lower := []string{"/images/l/B", "/images/l/A"}
+	opts, dir, err := overlayOptions(lower, "/upper", "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "" || opts != "upperdir=/upper,lowerdir=/images/l/B:/images/l/A,workdir=/work" {
+		t.Fatalf("opts = %s dir = %s", opts, dir)
+	}
+
+	// 层数多时改用相对路径
+	var deep []string
+	for i := 0; i < 120; i++ {
+		deep = append(deep, fmt.Sprintf("/workplace/duoker/images/l/%026d", i))
+	}
+	opts, dir, err = overlayOptions(deep, "/upper", "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/workplace/duoker/images/l" || strings.Contains(opts, "/workplace") {
+		t.Fatalf("expect relative lowerdirs, opts = %s dir = %s", opts, dir)
+	}
+
+	// 只读挂载时读写层不在镜像仓库中 保留绝对路径
+	upper := "/workplace/duoker/rootfs/wlayer/c1"
+	opts, dir, err = overlayOptions(append([]string{upper}, deep...), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/workplace/duoker/images/l" || !strings.HasPrefix(opts, "lowerdir="+upper+":0000") {
+		t.Fatalf("expect upper layer kept absolute, opts = %s dir = %s", opts[:80], dir)
+	}
+
+	// 相对路径也放不下时报错
+	for i := 120; i < 500; i++ {
+		deep = append(deep, fmt.Sprintf("/workplace/duoker/images/l/%026d", i))
+	}
+	if _, _, err := overlayOptions(deep, "/upper", "/work"); err == nil {
+		t.Fatal("expect error for too many layers")
+	}
+}